@@ -1,22 +1,16 @@
 package app
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
-	"path"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
-	. "strings"
 	"time"
 
 	"github.com/bvinc/go-sqlite-lite/sqlite3"
@@ -33,16 +27,27 @@ type item struct {
 	Id       int
 	ItemType string `json:"type"`
 	Deleted  bool
+	Dead     bool
+
+	By   string
+	Time int64
 
 	Kids []int
 
 	// Story Json
-	Title string
+	Title       string
+	Url         string
+	Score       int
+	Descendants int
 
 	// Comment Json
 	Text   string
 	Parent int
 
+	// Poll Json
+	Parts []int
+	Poll  int
+
 	// Comment custom
 	// Level  int
 	// Number int
@@ -72,599 +77,295 @@ type wordConfig struct {
 	WordScores map[int][]int
 }
 
-func Import(dir string) {
-	dir = TrimSpace(Trim(dir, "\""))
+// Query prints the number of matching stories and comments and lists the
+// best matching comments, ordered by bm25. With full, the whole comment is
+// printed instead of a snippet.
+func Query(query string, kinds []string, limit int, offset int, full bool) {
 
-	stat, err := os.Stat(dir)
+	progressf("Running Query: [%s]", query)
 
-	if err != nil {
-		fmt.Printf("Path error: %s\n", err)
-		os.Exit(1)
-	}
+	searchQuery := parseSearchQueryOrExit(query)
 
-	if !stat.IsDir() {
-		fmt.Printf("Path is not a directory\n")
-		os.Exit(1)
-	}
+	conn := openDatabase()
+	defer conn.Close()
 
-	if dir, err = filepath.Abs(dir); err != nil {
-		fmt.Printf("Failed to get absolute path\n")
-		os.Exit(1)
-	}
+	err := searchComments(conn, searchQuery, kinds, limit, offset, full)
+	check(err, "Failed to run query")
+}
+
+// countMatches returns the number of stories and comments matching query.
+func countMatches(conn *sqlite3.Conn, query *searchQuery, kinds []string) (int, int, error) {
+	kindSql, kindArgs := kindCondition(kinds)
 
-	fmt.Printf("Importing data from [%s]\n", dir)
+	storySql, storyArgs := query.storyCondition()
+	commentSql, commentArgs := query.commentCondition()
 
-	openDir, err := os.Open(dir)
+	storiesFound, err := tryQueryScalar(conn,
+		"SELECT COUNT(*) FROM StoriesContent INNER JOIN Stories ON (Stories.StoryId = StoriesContent.rowid) "+
+			"WHERE "+storySql+" AND "+kindSql, append(storyArgs, kindArgs...)...)
 	if err != nil {
-		fmt.Printf("Failed to open directory\n")
+		return 0, 0, err
 	}
 
-	defer openDir.Close()
-
-	dirEntries, err := openDir.Readdir(0)
+	commentsFound, err := tryQueryScalar(conn,
+		"SELECT COUNT(*) FROM CommentsContent INNER JOIN Comments ON (Comments.CommentId = CommentsContent.rowid) "+
+			"LEFT JOIN Stories ON (Stories.StoryId = Comments.StoryId) "+
+			"WHERE "+commentSql+" AND "+kindSql, append(commentArgs, kindArgs...)...)
 	if err != nil {
-		fmt.Printf("Failed to read directory\n")
-		os.Exit(1)
+		return 0, 0, err
 	}
 
-	var fileNames []string
+	return storiesFound, commentsFound, nil
+}
 
-	for i := 0; i < len(dirEntries); i++ {
-		if !dirEntries[i].IsDir() {
-			fileNames = append(fileNames, dirEntries[i].Name())
-		}
+// searchComments prints the counts and lists the matching comments from offset on.
+// Errors are returned, because a bad query shouldn't end the shell.
+func searchComments(conn *sqlite3.Conn, query *searchQuery, kinds []string, limit int, offset int, full bool) error {
+	storiesFound, commentsFound, err := countMatches(conn, query, kinds)
+	if err != nil {
+		return err
 	}
 
-	databasePath := "hacker-bro.db"
-
-	if false && fileExists(databasePath) {
-		fmt.Printf("Removing old database: %s\n", databasePath)
-		os.Remove(databasePath)
+	// Counts are progress in structured output. The results are the listed comments.
+	var results *resultWriter
+	if Output != OutputText {
+		results = newResultWriter()
+		defer results.close()
 	}
 
-	fmt.Println()
-	fmt.Printf("Opening database: %s\n", databasePath)
-
-	conn, err := sqlite3.Open(databasePath)
-	if err != nil {
-		fmt.Printf("Could not open database\n")
-		os.Exit(1)
+	if storiesFound > 0 || commentsFound > 0 {
+		resultf("Stories: %d\n", storiesFound)
+		resultf("Comments: %d\n", commentsFound)
+	} else {
+		resultf("No results. Sorry.\n")
+		return nil
 	}
 
-	defer conn.Close()
-
-	err = conn.Exec("CREATE TABLE IF NOT EXISTS Stories(StoryId INTEGER PRIMARY KEY, CommentCount INTEGER, File TEXT)")
-	check(err, "Failed to create Stories table")
-
-	err = conn.Exec("CREATE TABLE IF NOT EXISTS Comments(CommentId INTEGER PRIMARY KEY, StoryId INTEGER, Parent INTEGER, Thread INTEGER, Level INTEGER, File TEXT)")
-	check(err, "Failed to create Comments table")
-
-	err = conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS StoriesContent USING fts5(Content)")
-	check(err, "Failed to create StoriesContent table")
-
-	err = conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS CommentsContent USING fts5(Content)")
-	check(err, "Failed to create CommentsContent table")
-
-	// fmt.Printf("Deleting old data\n")
-
-	// deleteItems(conn, "Stories")
-	// deleteItems(conn, "Comments")
-
-	fmt.Printf("Reading known files from database...\n")
-
-	knownFiles := make(map[string]struct{})
-	var knownFile string
-
-	{
-		stmt, err := conn.Prepare("SELECT DISTINCT File FROM Stories")
-		check(err, "Failed to create query statememt")
-
-		defer stmt.Close()
-
-		for {
-			hasRows, err := stmt.Step()
-			check(err, "Failed to step")
-
-			if !hasRows {
-				break
-			}
-
-			err = stmt.Scan(&knownFile)
-			check(err, "Failed to scan")
-
-			knownFiles[knownFile] = struct{}{}
-		}
+	if offset >= commentsFound {
+		return nil
 	}
 
-	readStoryCounter := 0
-	newStoryCounter := 0
-	deletedStoryCounter := 0
-	noCommentsStoryCounter := 0
-	askHnStoryCounter := 0
-	emptyStoryCounter := 0
-
-	readCommentCounter := 0
-	newCommentCounter := 0
-	deletedCommentCounter := 0
-	emptyCommentCounter := 0
+	lastResult := offset + limit
+	if lastResult > commentsFound {
+		lastResult = commentsFound
+	}
 
-	readJobCounter := 0
-	readPollCounter := 0
+	resultf("\n")
+	resultf("Comments %d-%d of %d:\n", offset+1, lastResult, commentsFound)
 
-	var newStories []item
-	var newComments []item
+	// Matches are marked like bold Markdown
+	contentSql := "snippet(CommentsContent, 0, '**', '**', '...', 24)"
+	if full {
+		contentSql = "highlight(CommentsContent, 0, '**', '**')"
+	}
 
-	newStoriesMap := make(map[int]item)
+	orderSql := "bm25(CommentsContent)"
 
-	for i := 0; i < len(fileNames); i++ {
+	// Without text, there is nothing to highlight or rank
+	if query.match == "" {
+		contentSql = "CommentsContent.Content"
+		orderSql = "Comments.CommentId"
+	}
 
-		fileName := fileNames[i]
-		filePath := path.Join(dir, fileName)
+	kindSql, kindArgs := kindCondition(kinds)
+	commentSql, commentArgs := query.commentCondition()
 
-		fmt.Printf("Loading [%s]...\n", fileName)
+	stmt, err := conn.Prepare(
+		"SELECT Comments.CommentId, Comments.StoryId, IFNULL(StoriesContent.Content, ''), Comments.Thread, Comments.Level, "+contentSql+" "+
+			"FROM CommentsContent INNER JOIN Comments ON (Comments.CommentId = CommentsContent.rowid) "+
+			"LEFT JOIN Stories ON (Stories.StoryId = Comments.StoryId) "+
+			"LEFT JOIN StoriesContent ON (StoriesContent.rowid = Stories.StoryId) "+
+			"WHERE "+commentSql+" AND "+kindSql+" "+
+			"ORDER BY "+orderSql+" LIMIT ? OFFSET ?",
+		append(append(commentArgs, kindArgs...), limit, offset)...)
+	if err != nil {
+		return err
+	}
 
-		if _, hasKey := knownFiles[fileName]; hasKey {
-			fmt.Printf("Skipping [%s]. Already imported.\n", fileName)
-			continue
-		}
+	defer stmt.Close()
 
-		openFile, err := os.Open(filePath)
+	for {
+		hasRows, err := stmt.Step()
 		if err != nil {
-			fmt.Printf("Failed to open [%s]\n", fileName)
-			os.Exit(1)
-		}
-
-		defer openFile.Close()
-
-		var items []item
-		lineCounter := 0
-		reader := bufio.NewReader(openFile)
-
-		for {
-			var lineBuffer bytes.Buffer
-			var tempBuffer []byte
-			var isPrefix bool
-
-			lineCounter++
-
-			for {
-				tempBuffer, isPrefix, err = reader.ReadLine()
-				lineBuffer.Write(tempBuffer)
-
-				if !isPrefix {
-					break
-				}
-
-				if err != nil {
-					// EOF
-					break
-				}
-			}
-
-			if err == io.EOF {
-				break
-			}
-
-			line := lineBuffer.Bytes()
-
-			var item item
-			if err := json.Unmarshal(line, &item); err != nil {
-				fmt.Printf("Failed to parse [%s] on line [%d]: %s\n", fileName, lineCounter, err)
-				os.Exit(1)
-			}
-
-			item.fileName = fileName
-
-			items = append(items, item)
+			return err
 		}
 
-		fmt.Printf("Read [%d] items from [%s]\n", len(items), fileNames[i])
-
-		for i := 0; i < len(items); i++ {
-			currentItem := items[i]
-
-			if currentItem.ItemType == "story" {
-				readStoryCounter++
-
-				if currentItem.Deleted {
-					deletedStoryCounter++
-					continue
-				}
-
-				if len(currentItem.Title) == 0 {
-					emptyStoryCounter++
-					continue
-				}
-
-				if len(currentItem.Kids) == 0 {
-					noCommentsStoryCounter++
-					continue
-				}
-
-				if strings.HasPrefix(currentItem.Title, "Ask HN:") {
-					askHnStoryCounter++
-					continue
-				}
-
-				newStoryCounter++
-				newStories = append(newStories, currentItem)
-				newStoriesMap[currentItem.Id] = currentItem
-
-			} else if currentItem.ItemType == "comment" {
-
-				readCommentCounter++
-
-				if currentItem.Deleted {
-					deletedCommentCounter++
-					continue
-				}
-
-				if len(currentItem.Text) == 0 {
-					emptyCommentCounter++
-					continue
-				}
-
-				newCommentCounter++
-				newComments = append(newComments, currentItem)
-
-			} else if currentItem.ItemType == "job" {
-
-				readJobCounter++
-
-			} else if currentItem.ItemType == "poll" || currentItem.ItemType == "pollopt" {
-
-				// TODO: What is the difference between poll and pollopt ?
-				readPollCounter++
-
-			} else {
-
-				fmt.Printf("Line [%d]: Unknown item type [%s]\n", i+1, currentItem.ItemType)
-				os.Exit(1)
-			}
+		if !hasRows {
+			break
 		}
-	}
-
-	fmt.Println()
-	fmt.Printf("Read stories: %d\n", readStoryCounter)
-	fmt.Printf("New valid stories: %d\n", newStoryCounter)
-	fmt.Printf("Deleted stories: %d\n", deletedStoryCounter)
-	fmt.Printf("Empty stories: %d\n", emptyStoryCounter)
-	fmt.Printf("Ask HN stories: %d\n", askHnStoryCounter)
-	fmt.Printf("Stories with no comments: %d\n", noCommentsStoryCounter)
 
-	fmt.Println()
-	fmt.Printf("Read jobs: %d\n", readJobCounter)
-
-	fmt.Println()
-	fmt.Printf("Read polls: %d\n", readPollCounter)
-
-	fmt.Println()
-	fmt.Printf("Read comments: %d\n", readCommentCounter)
-	fmt.Printf("New valid comments: %d\n", newCommentCounter)
-	fmt.Printf("Deleted comments: %d\n", deletedCommentCounter)
-	fmt.Printf("Empty comments: %d\n", emptyCommentCounter)
-
-	fmt.Printf("Inserting new data\n")
-
-	progressTime := time.Now()
-	progressIteration := 0
-	currentStoryIndex := 0
-	newStoriesCount := len(newStories)
-	newCommentsCount := len(newComments)
-
-	// err = conn.Exec("PRAGMA journal_mode = OFF")
-	// check(err, "PRAGMA failed")
-
-	// err = conn.Exec("PRAGMA synchronous = OFF")
-	// check(err, "PRAGMA failed")
-
-	err = conn.Begin()
-	check(err, "Failed to start transaction")
-
-	stmtInsertStories, err := conn.Prepare("INSERT INTO Stories (StoryId, File, CommentCount) Values(?, ?, 0)")
-	check(err, "Failed to prepare statement")
-	defer stmtInsertStories.Close()
-
-	stmtInsertStoriesContent, err := conn.Prepare("INSERT INTO StoriesContent (rowid, Content) Values(?, ?)")
-	check(err, "Failed to prepare statement")
-	defer stmtInsertStoriesContent.Close()
-
-	for _, storyItem := range newStories {
-
-		// TODO: Check if len(item.items) == len(item.kids)
-
-		// TODO: Sometimes item.Text seems to be set filled for Stories. When and why?
-		_ = stmtInsertStories.Exec(storyItem.Id, storyItem.fileName)
-		_ = stmtInsertStoriesContent.Exec(storyItem.Id, storyItem.Title)
-
-		currentStoryIndex++
-
-		progressIteration++
-		if progressIteration%1000 == 0 && time.Since(progressTime).Seconds() > 2 {
-
-			progressPerSeconds := float64(progressIteration) / time.Since(progressTime).Seconds()
-			progress := float64(currentStoryIndex) / float64(newStoriesCount) * 100.0
-
-			fmt.Printf("DB Insert %d of %d / %.1f%% / %0.1f stories per sec\n", currentStoryIndex, newStoriesCount, progress, progressPerSeconds)
+		var commentId int
+		var storyId int
+		var title string
+		var thread int
+		var level int
+		var content string
 
-			progressTime = time.Now()
-			progressIteration = 0
+		err = stmt.Scan(&commentId, &storyId, &title, &thread, &level, &content)
+		if err != nil {
+			return err
 		}
-	}
 
-	progressTime = time.Now()
-	progressIteration = 0
-	currentCommentIndex := 0
-
-	reRemoveTags := regexp.MustCompile(`<.*?>`)
-	reRemoveUrls := regexp.MustCompile(`\bhttps?\:.*?(\s|$)`)
-	reRemoveQuoteStarts := regexp.MustCompile(`(?m)^>+\s*`)
-	reRemoveSingleQuotes := regexp.MustCompile(`[^\w]'|'[\w]`) // Want to remove 'this', but not I'm.
-	reRemoveBraces := regexp.MustCompile(`\[.*?\]`)
-
-	stmtInsertComments, err := conn.Prepare("INSERT INTO Comments (CommentId, StoryId, Parent, Thread, Level, File) Values(?, 0, ?, 0, 0, ?)")
-	check(err, "Failed to prepare statement")
-	defer stmtInsertComments.Close()
-
-	stmtInsertCommentsContent, err := conn.Prepare("INSERT INTO CommentsContent (rowid, Content) Values(?, ?)")
-	check(err, "Failed to prepare statement")
-	defer stmtInsertCommentsContent.Close()
-
-	for _, commentItem := range newComments {
-
-		comment := commentItem.Text
-
-		if strings.Contains(comment, "&") {
-			// comment = strings.Replace(comment, "&quot;", "", -1)
-			comment = strings.Replace(comment, "&quot;", "", -1)  // Double Quotes: "
-			comment = strings.Replace(comment, "&#x27;", "'", -1) // Single Quotes: '
-			comment = strings.Replace(comment, "&#x2F;", "/", -1)
-			comment = strings.Replace(comment, "&gt;", ">", -1)
-			comment = strings.Replace(comment, "&lt;", "<", -1)
-			comment = strings.Replace(comment, "&amp;", "&", -1)
+		if results != nil {
+			results.write(
+				resultField{"commentId", commentId},
+				resultField{"storyId", storyId},
+				resultField{"title", title},
+				resultField{"thread", thread},
+				resultField{"level", level},
+				resultField{"content", content})
+			continue
 		}
 
-		comment = reRemoveTags.ReplaceAllString(comment, " ")
-		comment = reRemoveBraces.ReplaceAllString(comment, " ")
-		comment = reRemoveUrls.ReplaceAllString(comment, " ")
-		comment = reRemoveQuoteStarts.ReplaceAllString(comment, "")
-		comment = reRemoveSingleQuotes.ReplaceAllString(comment, "")
-
-		_ = stmtInsertComments.Exec(commentItem.Id, commentItem.Parent, commentItem.fileName)
-		_ = stmtInsertCommentsContent.Exec(commentItem.Id, comment)
-
-		currentCommentIndex++
-
-		progressIteration++
-		if progressIteration%1000 == 0 && time.Since(progressTime).Seconds() > 2 {
-
-			progressPerSeconds := float64(progressIteration) / time.Since(progressTime).Seconds()
-			progress := float64(currentCommentIndex) / float64(newCommentsCount) * 100.0
-
-			fmt.Printf("DB Insert %d of %d / %.1f%% / %0.1f comments per sec\n", currentCommentIndex, newCommentsCount, progress, progressPerSeconds)
-
-			progressTime = time.Now()
-			progressIteration = 0
-		}
+		resultf("\n")
+		resultf("[%d] %s (story %d, thread %d, level %d)\n", commentId, title, storyId, thread, level)
+		resultf("%s\n", indentText(content, "    "))
 	}
 
-	fmt.Printf("Committing data...\n")
-
-	err = conn.Commit()
-	check(err, "Failed to commit transaction")
-
-	fmt.Printf("Loading story id's and comment count...\n")
-
-	storyId := 0
-	commentCount := 0
-	storyCommentCount := make(map[int]int)
-	storyIds := make(map[int]struct{})
-
-	{
-		stmt, err := conn.Prepare("SELECT StoryId, CommentCount FROM Stories")
-		check(err, "Failed to create query statememt")
+	return nil
+}
 
-		defer stmt.Close()
+// TopDomains prints the most linked domains.
+func TopDomains(limit int) {
+	progressf("Most linked domains...")
 
-		for {
-			hasRows, err := stmt.Step()
-			check(err, "Failed to step")
+	conn := openDatabase()
+	defer conn.Close()
 
-			if !hasRows {
-				break
-			}
+	stmt, err := conn.Prepare(
+		"SELECT Domain, COUNT(*) AS LinkCount, COUNT(DISTINCT CommentId) FROM Links WHERE Domain != '' "+
+			"GROUP BY Domain ORDER BY LinkCount DESC LIMIT ?", limit)
+	check(err, "Failed to create query statememt")
 
-			err = stmt.Scan(&storyId, &commentCount)
-			check(err, "Failed to scan")
+	defer stmt.Close()
 
-			storyCommentCount[storyId] = commentCount
-			storyIds[storyId] = struct{}{}
-		}
+	var results *resultWriter
+	if Output != OutputText {
+		results = newResultWriter()
+		defer results.close()
 	}
 
-	fmt.Printf("Loading comment tree...\n")
-
-	storyId = 0
-	commentId := 0
-	commentParent := 0
-
-	commentParents := make(map[int]int)
-	var commentsToCheck []int
-
-	{
-		stmt, err := conn.Prepare("SELECT StoryId, CommentId, Parent FROM Comments")
-		check(err, "Failed to create query statememt")
-
-		defer stmt.Close()
-
-		for {
-			hasRows, err := stmt.Step()
-			check(err, "Failed to step")
-
-			if !hasRows {
-				break
-			}
-
-			err = stmt.Scan(&storyId, &commentId, &commentParent)
-			check(err, "Failed to scan")
-
-			commentParents[commentId] = commentParent
+	for {
+		hasRows, err := stmt.Step()
+		check(err, "Failed to step")
 
-			if storyId == 0 {
-				commentsToCheck = append(commentsToCheck, commentId)
-			}
+		if !hasRows {
+			break
 		}
-	}
-
-	fmt.Printf("Setting comment parents...\n")
-
-	lostCommentCount := 0
-	storyWithNewCommentCount := make(map[int]struct{})
-
-	err = conn.Begin()
-	check(err, "Failed to start transaction")
-
-	stmtUpdateComments, err := conn.Prepare("UPDATE Comments SET StoryId = ?, Thread = ?, Level = ? WHERE CommentId = ?")
-	check(err, "Failed to prepare statement")
-
-	defer stmtUpdateComments.Close()
 
-	for _, commentId := range commentsToCheck {
-
-		storyId, level, threadId := findParent(commentParents, storyIds, commentId, 1)
-		if storyId > 0 {
-
-			thread := 0
-			story, hasKey := newStoriesMap[storyId]
-			if hasKey {
-				for threadIdIndex, currentThreadId := range story.Kids {
-					if currentThreadId == threadId {
-						thread = threadIdIndex + 1
-						break
-					}
-				}
-			}
+		var domain string
+		var linkCount int
+		var commentCount int
 
-			_ = stmtUpdateComments.Exec(storyId, thread, level, commentId)
+		err = stmt.Scan(&domain, &linkCount, &commentCount)
+		check(err, "Failed to scan")
 
-			storyCommentCount[storyId] += 1
-			storyWithNewCommentCount[storyId] = struct{}{}
-		} else {
-			lostCommentCount++
+		if results != nil {
+			results.write(resultField{"domain", domain}, resultField{"links", linkCount}, resultField{"comments", commentCount})
+			continue
 		}
-	}
-
-	fmt.Printf("Setting new comment count of stories...\n")
-
-	stmtUpdateCommentCounts, err := conn.Prepare("UPDATE Stories SET CommentCount = ? WHERE StoryId = ?")
-	check(err, "Failed to prepare statement")
-
-	defer stmtUpdateCommentCounts.Close()
-
-	for storyId := range storyWithNewCommentCount {
-		commentCount := storyCommentCount[storyId]
-
-		_ = stmtUpdateCommentCounts.Exec(commentCount, storyId)
-	}
-
-	err = conn.Commit()
-	check(err, "Failed to commit transaction")
-}
 
-func findParent(commentParents map[int]int, storyIds map[int]struct{}, commentId int, level int) (foundStoryId int, foundLevel int, threadId int) {
-	if parentId, hasKey := commentParents[commentId]; hasKey {
-		if _, hasKey := storyIds[parentId]; hasKey {
-			return parentId, level, commentId
-		}
-		return findParent(commentParents, storyIds, parentId, level+1)
-	} else {
-		return 0, 0, 0
+		resultf("%8d links in %8d comments: %s\n", linkCount, commentCount, domain)
 	}
 }
 
-func Query(query string) {
-
-	// Not possible to search for qoutes with fts: https://bit.ly/30O4zSc
-	//query = Replace(Trim(query, "\""), "'", "''", -1)
-	fmt.Printf("Running Query: [%s]", query)
+// ParentQuotes prints comments, which quote their parent comment.
+func ParentQuotes(limit int) {
+	progressf("Comments quoting their parent...")
 
 	conn := openDatabase()
 	defer conn.Close()
 
-	storiesFound := 0
+	parentQuotesSql :=
+		"FROM Quotes INNER JOIN Comments ON (Comments.CommentId = Quotes.CommentId) " +
+			"INNER JOIN CommentsContent AS ParentContent ON (ParentContent.rowid = Comments.Parent) " +
+			"WHERE INSTR(ParentContent.Content, Quotes.Text) > 0"
 
-	{
-		stmt, err := conn.Prepare("SELECT COUNT(*) FROM StoriesContent WHERE Content MATCH ?", query)
-		check(err, "Failed to create query statememt")
+	quotingCount := queryScalar(conn, "SELECT COUNT(DISTINCT Quotes.CommentId) "+parentQuotesSql)
+	quoteCount := queryScalar(conn, "SELECT COUNT(*) FROM Quotes")
 
-		defer stmt.Close()
+	resultf("Comments quoting their parent: %d\n", quotingCount)
+	resultf("Quotes in total: %d\n", quoteCount)
 
-		for {
-			hasRows, err := stmt.Step()
-			check(err, "Failed to step")
+	stmt, err := conn.Prepare("SELECT Quotes.CommentId, Comments.Parent, Quotes.Text "+parentQuotesSql+" ORDER BY Quotes.CommentId DESC LIMIT ?", limit)
+	check(err, "Failed to create query statememt")
 
-			if !hasRows {
-				break
-			}
+	defer stmt.Close()
 
-			err = stmt.Scan(&storiesFound)
-			check(err, "Failed to scan")
-		}
+	var results *resultWriter
+	if Output != OutputText {
+		results = newResultWriter()
+		defer results.close()
 	}
 
-	commentsFound := 0
-
-	{
-		stmt, err := conn.Prepare("SELECT COUNT(*) FROM CommentsContent WHERE Content MATCH ?", query)
-		check(err, "Failed to create query statememt")
+	for {
+		hasRows, err := stmt.Step()
+		check(err, "Failed to step")
 
-		defer stmt.Close()
+		if !hasRows {
+			break
+		}
 
-		for {
-			hasRows, err := stmt.Step()
-			check(err, "Failed to step")
+		var commentId int
+		var parentId int
+		var quote string
 
-			if !hasRows {
-				break
-			}
+		err = stmt.Scan(&commentId, &parentId, &quote)
+		check(err, "Failed to scan")
 
-			err = stmt.Scan(&commentsFound)
-			check(err, "Failed to scan")
+		if results != nil {
+			results.write(resultField{"commentId", commentId}, resultField{"parentId", parentId}, resultField{"quote", quote})
+			continue
 		}
-	}
 
-	if storiesFound > 0 || commentsFound > 0 {
-		fmt.Printf("Stories: %d\n", storiesFound)
-		fmt.Printf("Comments: %d\n", commentsFound)
-	} else {
-		fmt.Println("No results. Sorry.")
+		resultf("[%d] quotes [%d]: %s\n", commentId, parentId, quote)
 	}
 }
 
-func Rank(filter string, outPath string, commentLimit int, verbose bool) {
-	fmt.Printf("Ranking comments...")
+func Rank(filter string, kinds []string, outPath string, commentLimit int, verbose bool) {
+	progressf("Ranking comments...")
+
+	var filterQuery *searchQuery
+	if filter != "" {
+		filterQuery = parseSearchQueryOrExit(filter)
+	}
 
 	conn := openDatabase()
 	defer conn.Close()
 
-	fmt.Printf("Initializing comment score...\n")
+	progressf("Initializing comment score...\n")
 
 	var err error
 
 	commentScores := make(map[int]int)
 
+	kindSql, kindArgs := kindCondition(kinds)
+
+	if len(kinds) > 0 {
+		progressf("Using stories of kind [%s]\n", strings.Join(kinds, ", "))
+	}
+
 	{
 		var stmt *sqlite3.Stmt
 
 		if filter != "" {
-			fmt.Printf("Loading comment ids with filter [%s]...\n", filter)
+			progressf("Loading comment ids with filter [%s]...\n", filter)
+
+			filterSql, filterArgs := filterQuery.commentCondition()
 
-			stmt, err = conn.Prepare("SELECT CommentId FROM Comments INNER JOIN CommentsContent ON (CommentsContent.rowid = Comments.CommentId) WHERE Comments.StoryId > 0 AND CommentsContent.Content MATCH ?", filter)
+			stmt, err = conn.Prepare(
+				"SELECT CommentId FROM Comments INNER JOIN CommentsContent ON (CommentsContent.rowid = Comments.CommentId) "+
+					"INNER JOIN Stories ON (Stories.StoryId = Comments.StoryId) "+
+					"WHERE Comments.StoryId > 0 AND "+filterSql+" AND "+kindSql,
+				append(filterArgs, kindArgs...)...)
 			check(err, "Failed to create query statememt")
 		} else {
-			fmt.Printf("Loading comment ids without filter...\n")
+			progressf("Loading comment ids without filter...\n")
 
-			stmt, err = conn.Prepare("SELECT CommentId FROM Comments WHERE StoryId > 0")
+			stmt, err = conn.Prepare(
+				"SELECT CommentId FROM Comments INNER JOIN Stories ON (Stories.StoryId = Comments.StoryId) "+
+					"WHERE Comments.StoryId > 0 AND "+kindSql,
+				kindArgs...)
 			check(err, "Failed to create query statememt")
 		}
 
@@ -687,9 +388,9 @@ func Rank(filter string, outPath string, commentLimit int, verbose bool) {
 	{
 		var stmt *sqlite3.Stmt
 
-		fmt.Printf("Increase score for comments with low thread number...\n")
+		progressf("Increase score for comments with low thread number...\n")
 
-		stmt, err = conn.Prepare("SELECT CommentId FROM Comments WHERE Thread <= 3")
+		stmt, err = conn.Prepare("SELECT CommentId FROM Comments WHERE Thread BETWEEN 1 AND 3")
 		check(err, "Failed to create query statememt")
 
 		defer stmt.Close()
@@ -714,9 +415,9 @@ func Rank(filter string, outPath string, commentLimit int, verbose bool) {
 	{
 		var stmt *sqlite3.Stmt
 
-		fmt.Printf("Increase score for comments with low thread number and low level...\n")
+		progressf("Increase score for comments with low thread number and low level...\n")
 
-		stmt, err = conn.Prepare("SELECT CommentId FROM Comments WHERE Thread <= 3 AND Level <= 2")
+		stmt, err = conn.Prepare("SELECT CommentId FROM Comments WHERE Thread BETWEEN 1 AND 3 AND Level <= 2")
 		check(err, "Failed to create query statememt")
 
 		defer stmt.Close()
@@ -741,7 +442,7 @@ func Rank(filter string, outPath string, commentLimit int, verbose bool) {
 	{
 		var stmt *sqlite3.Stmt
 
-		fmt.Printf("Increase score for comments in threads with high participation...\n")
+		progressf("Increase score for comments in threads with high participation...\n")
 
 		stmt, err = conn.Prepare("SELECT CommentId FROM Comments INNER JOIN Stories ON (Comments.StoryId = Stories.StoryId) WHERE Stories.CommentCount >= 20")
 		check(err, "Failed to create query statememt")
@@ -766,7 +467,36 @@ func Rank(filter string, outPath string, commentLimit int, verbose bool) {
 	}
 
 	{
-		fmt.Printf("Storing new scores in a temporary table...\n")
+		var stmt *sqlite3.Stmt
+
+		progressf("Decrease score for comments, which are mostly quotation...\n")
+
+		stmt, err = conn.Prepare(
+			"SELECT Quotes.CommentId FROM Quotes INNER JOIN CommentsContent ON (CommentsContent.rowid = Quotes.CommentId) " +
+				"GROUP BY Quotes.CommentId HAVING SUM(LENGTH(Quotes.Text)) * 2 > MAX(LENGTH(CommentsContent.Content))")
+		check(err, "Failed to create query statememt")
+
+		defer stmt.Close()
+
+		for {
+			hasRows, _ := stmt.Step()
+
+			if !hasRows {
+				break
+			}
+
+			var commentId int
+
+			_ = stmt.Scan(&commentId)
+
+			if score, hasKey := commentScores[commentId]; hasKey && score > 0 {
+				commentScores[commentId] -= 1
+			}
+		}
+	}
+
+	{
+		progressf("Storing new scores in a temporary table...\n")
 
 		err = conn.Exec("CREATE TABLE IF NOT EXISTS temp.Scores (CommentId INT PRIMARY KEY, Score INT)")
 		check(err, "Failed to create temp table")
@@ -791,25 +521,35 @@ func Rank(filter string, outPath string, commentLimit int, verbose bool) {
 	}
 
 	totalStoriesCount := queryScalar(conn, "SELECT COUNT(*) FROM Stories")
-	fmt.Printf("Total stories: %d\n", totalStoriesCount)
+	resultf("Total stories: %d\n", totalStoriesCount)
 
 	usedStoriesCount := queryScalar(conn, "SELECT COUNT(DISTINCT StoryId) FROM Comments INNER JOIN temp.Scores ON (Comments.CommentId = temp.Scores.CommentId)")
-	fmt.Printf("Used stories: %d\n", usedStoriesCount)
+	resultf("Used stories: %d\n", usedStoriesCount)
 
 	totalCommentsCount := queryScalar(conn, "SELECT COUNT(*) FROM Comments")
-	fmt.Printf("Total comments: %d\n", totalCommentsCount)
+	resultf("Total comments: %d\n", totalCommentsCount)
 
 	usedComments := queryScalar(conn, "SELECT COUNT(*) FROM temp.Scores")
-	fmt.Printf("Used comments: %d\n", usedComments)
+	resultf("Used comments: %d\n", usedComments)
+
+	if Output != OutputText {
+		results := newResultWriter()
+		results.write(
+			resultField{"totalStories", totalStoriesCount},
+			resultField{"usedStories", usedStoriesCount},
+			resultField{"totalComments", totalCommentsCount},
+			resultField{"usedComments", usedComments})
+		results.close()
+	}
 
-	fmt.Printf("Preparing output file\n")
+	progressf("Preparing output file\n")
 
 	wordConf := generateWordMap(conn, commentLimit, verbose)
 
 	var jsonString []byte
 
 	if verbose {
-		fmt.Printf("Serializing output file (indented)\n")
+		progressf("Serializing output file (indented)\n")
 
 		jsonString, err = json.MarshalIndent(wordConf, "", "\t")
 		check(err, "Failed to serialize config file\n")
@@ -818,63 +558,64 @@ func Rank(filter string, outPath string, commentLimit int, verbose bool) {
 		check(err, "Failed to serialize config file\n")
 	}
 
-	fmt.Printf("Writing output file\n")
+	progressf("Writing output file\n")
 
 	err = ioutil.WriteFile(outPath, jsonString, os.ModePerm)
 	check(err, "Failed to write config file\n")
 
-	fmt.Printf("Done: [%s]\n", outPath)
+	progressf("Done: [%s]\n", outPath)
 }
 
 func Status() {
-	fmt.Printf("Getting status information...")
+	progressf("Getting status information...")
 
 	conn := openDatabase()
 	defer conn.Close()
 
+	schemaVersion := queryScalar(conn,
+		"SELECT MAX(Version) FROM schema_version")
+
+	resultf("Schema version %d\n", schemaVersion)
+
+	// Files imported before ImportedFiles existed are only known from their items
 	fileCount := queryScalar(conn,
-		"SELECT COUNT(DISTINCT File) FROM Stories")
+		"SELECT COUNT(File) FROM (SELECT File FROM ImportedFiles UNION SELECT File FROM Stories UNION SELECT File FROM Comments)")
 
-	fmt.Printf("%d files\n", fileCount)
+	resultf("%d files\n", fileCount)
 
 	storyCount := queryScalar(conn,
 		"SELECT COUNT(*) FROM Stories")
 
-	fmt.Printf("%d stories\n", storyCount)
+	resultf("%d stories\n", storyCount)
 
 	commentCount := queryScalar(conn,
 		"SELECT COUNT(*) FROM Comments")
 
-	fmt.Printf("%d comments\n", commentCount)
+	resultf("%d comments\n", commentCount)
+
+	if Output != OutputText {
+		results := newResultWriter()
+		results.write(
+			resultField{"database", DatabasePath},
+			resultField{"schemaVersion", schemaVersion},
+			resultField{"files", fileCount},
+			resultField{"stories", storyCount},
+			resultField{"comments", commentCount})
+		results.close()
+	}
 }
 
-func Talk(wordConfigPath string, talkCount int, continuity int, stability int, talkInit string, randSeed1 int, randSeed2 int, verbose bool) {
-
-	var wordConf wordConfig
-
-	fmt.Printf("Reading word map [%s]...\n", wordConfigPath)
-
-	file, err := ioutil.ReadFile(wordConfigPath)
-	check(err, "Failed to read config file\n")
-
-	fmt.Printf("Parsing word map...\n")
-
-	err = json.Unmarshal(file, &wordConf)
-	check(err, "Failed to unserialize config file\n")
-
-	fmt.Printf("Preparing word map...\n")
-
-	wordMap := make(map[WordKey][]wordInfo)
-
-	for currentWordKeyIndex, currentWordKey := range wordConf.WordKeys {
-		nextWordCount := len(wordConf.WordMap[currentWordKeyIndex])
+// talkModel is a word map prepared for createTalk.
+type talkModel struct {
+	words    []string
+	wordKeys []WordKey
+	wordMap  map[WordKey][]wordInfo
+}
 
-		wordMap[currentWordKey] = make([]wordInfo, nextWordCount)
+func Talk(wordConfigPath string, talkCount int, continuity int, stability int, talkInit string, randSeed1 int, randSeed2 int, verbose bool) {
 
-		for i := 0; i < nextWordCount; i++ {
-			wordMap[currentWordKey][i] = wordInfo{wordConf.WordMap[currentWordKeyIndex][i], wordConf.WordScores[currentWordKeyIndex][i]}
-		}
-	}
+	model, err := loadTalkModel(wordConfigPath)
+	check(err, "Failed to load word map")
 
 	var randInit *rand.Rand
 	var randTalk *rand.Rand
@@ -892,7 +633,7 @@ func Talk(wordConfigPath string, talkCount int, continuity int, stability int, t
 		randInit = rand.New(rand.NewSource(int64(randSeed1)))
 
 		if verbose {
-			fmt.Printf("Using randInit seed [%d]\n", randSeed1)
+			progressf("Using randInit seed [%d]\n", randSeed1)
 		}
 
 		if i == 0 {
@@ -906,11 +647,89 @@ func Talk(wordConfigPath string, talkCount int, continuity int, stability int, t
 		randTalk = rand.New(rand.NewSource(int64(randSeed2)))
 
 		if verbose {
-			fmt.Printf("Using randTalk seed [%d]\n", randSeed2)
+			progressf("Using randTalk seed [%d]\n", randSeed2)
+		}
+
+		createTalk(model.words, model.wordKeys, model.wordMap, continuity, stability, talkInit, randInit, randTalk, verbose)
+	}
+}
+
+// loadTalkModel reads a word map written by Rank. Errors are returned, because
+// a bad file shouldn't end the shell.
+func loadTalkModel(wordConfigPath string) (talkModel, error) {
+
+	const wordIdDot = 1
+
+	var wordConf wordConfig
+
+	progressf("Reading word map [%s]...\n", wordConfigPath)
+
+	file, err := ioutil.ReadFile(wordConfigPath)
+	if err != nil {
+		return talkModel{}, err
+	}
+
+	progressf("Parsing word map...\n")
+
+	err = json.Unmarshal(file, &wordConf)
+	if err != nil {
+		return talkModel{}, fmt.Errorf("Invalid word map [%s]: %s", wordConfigPath, err)
+	}
+
+	if len(wordConf.Words) <= wordIdDot {
+		return talkModel{}, fmt.Errorf("Invalid word map [%s]: No words", wordConfigPath)
+	}
+
+	progressf("Preparing word map...\n")
+
+	wordMap := make(map[WordKey][]wordInfo)
+	hasKeyAfterDot := false
+
+	for currentWordKeyIndex, currentWordKey := range wordConf.WordKeys {
+		nextWordCount := len(wordConf.WordMap[currentWordKeyIndex])
+
+		// createTalk picks from the next words of a key by score
+		if nextWordCount == 0 {
+			return talkModel{}, fmt.Errorf("Invalid word map [%s]: No next words for key %d", wordConfigPath, currentWordKeyIndex)
+		}
+
+		for _, keyWordId := range []int{currentWordKey.Pre1, currentWordKey.Pre2, currentWordKey.Pre3} {
+			if keyWordId < 0 || keyWordId >= len(wordConf.Words) {
+				return talkModel{}, fmt.Errorf("Invalid word map [%s]: Unknown word %d in key %d", wordConfigPath, keyWordId, currentWordKeyIndex)
+			}
+		}
+
+		if currentWordKey.Pre1 == wordIdDot {
+			hasKeyAfterDot = true
+		}
+
+		wordMap[currentWordKey] = make([]wordInfo, nextWordCount)
+
+		if len(wordConf.WordScores[currentWordKeyIndex]) != nextWordCount {
+			return talkModel{}, fmt.Errorf("Invalid word map [%s]: Scores of key %d don't match", wordConfigPath, currentWordKeyIndex)
 		}
 
-		createTalk(wordConf.Words, wordConf.WordKeys, wordMap, continuity, stability, talkInit, randInit, randTalk, verbose)
+		for i := 0; i < nextWordCount; i++ {
+			wordId := wordConf.WordMap[currentWordKeyIndex][i]
+			if wordId < 0 || wordId >= len(wordConf.Words) {
+				return talkModel{}, fmt.Errorf("Invalid word map [%s]: Unknown word %d", wordConfigPath, wordId)
+			}
+
+			score := wordConf.WordScores[currentWordKeyIndex][i]
+			if score < 1 {
+				return talkModel{}, fmt.Errorf("Invalid word map [%s]: Score %d of key %d", wordConfigPath, score, currentWordKeyIndex)
+			}
+
+			wordMap[currentWordKey][i] = wordInfo{wordId, score}
+		}
 	}
+
+	// A talk starts with a word after a dot
+	if !hasKeyAfterDot {
+		return talkModel{}, fmt.Errorf("Invalid word map [%s]: No word follows a dot", wordConfigPath)
+	}
+
+	return talkModel{words: wordConf.Words, wordKeys: wordConf.WordKeys, wordMap: wordMap}, nil
 }
 
 func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordInfo, continuity int, stability int, talkInit string, randInit *rand.Rand, randTalk *rand.Rand, verbose bool) {
@@ -925,8 +744,8 @@ func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordIn
 	if talkInit == "" {
 		{
 			if verbose {
-				fmt.Println()
-				fmt.Printf("Find my first word...\n")
+				progressf("\n")
+				progressf("Find my first word...\n")
 			}
 
 			var keysAfterDot []WordKey
@@ -950,7 +769,7 @@ func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordIn
 		}
 
 		if verbose {
-			fmt.Printf("Using first word [%s]\n", words[pre1])
+			progressf("Using first word [%s]\n", words[pre1])
 		}
 	} else {
 		talkInit = strings.TrimSpace(strings.Trim(talkInit, "\""))
@@ -970,8 +789,8 @@ func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordIn
 		}
 
 		if verbose {
-			fmt.Println()
-			fmt.Printf("Using start of sentence [%s]\n", talkInit)
+			progressf("\n")
+			progressf("Using start of sentence [%s]\n", talkInit)
 		}
 	}
 
@@ -986,7 +805,7 @@ func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordIn
 		// TODO: needsShuffle Logic is unoptimized...
 
 		if verbose && chainCount > continuity {
-			fmt.Printf("Continuity detected: Chain: %d. Allowed: %d\n", chainCount, continuity)
+			progressf("Continuity detected: Chain: %d. Allowed: %d\n", chainCount, continuity)
 		}
 
 		if !sequenceFound || chainCount > continuity {
@@ -1051,18 +870,18 @@ func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordIn
 			wordId = wordInfo.wordId
 
 			if verbose {
-				fmt.Printf("[%s], [%s], [%s] =>", words[currentKey.Pre3], words[currentKey.Pre2], words[currentKey.Pre1])
+				progressf("[%s], [%s], [%s] =>", words[currentKey.Pre3], words[currentKey.Pre2], words[currentKey.Pre1])
 
 				for i := 0; i < wordInfoCount; i++ {
 					currentWordInfo := currentWordInfos[i]
-					fmt.Printf(" %d=[%s]", currentWordInfo.score, words[currentWordInfo.wordId])
+					progressf(" %d=[%s]", currentWordInfo.score, words[currentWordInfo.wordId])
 				}
 
-				fmt.Printf(" => Using [%s]\n", words[wordId])
+				progressf(" => Using [%s]\n", words[wordId])
 			}
 		} else {
 			if verbose {
-				fmt.Printf("No availabe sequence for [%s], [%s], [%s]\n", words[pre3], words[pre2], words[pre1])
+				progressf("No availabe sequence for [%s], [%s], [%s]\n", words[pre3], words[pre2], words[pre1])
 			}
 
 			wordId = wordIdDot
@@ -1080,7 +899,7 @@ func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordIn
 		}
 	}
 
-	fmt.Println()
+	progressf("\n")
 
 	punctuations := map[string]struct{}{
 		".": struct{}{},
@@ -1117,12 +936,12 @@ func createTalk(words []string, wordKeys []WordKey, wordMap map[WordKey][]wordIn
 		talk = talkInit + talk
 	}
 
-	fmt.Printf("Shit HN says:\n\n%s\n", talk)
+	resultf("Shit HN says:\n\n%s\n", talk)
 }
 
 func generateWordMap(conn *sqlite3.Conn, commentLimit int, verbose bool) wordConfig {
 
-	fmt.Printf("Preparing to query comments...\n")
+	progressf("Preparing to query comments...\n")
 
 	commentLimitPostfix := ""
 	if commentLimit > 0 {
@@ -1138,7 +957,7 @@ func generateWordMap(conn *sqlite3.Conn, commentLimit int, verbose bool) wordCon
 
 	defer stmt.Close()
 
-	fmt.Printf("Loading comments...\n")
+	progressf("Loading comments...\n")
 
 	progressTime := time.Now()
 	progressIteration := 0
@@ -1155,7 +974,7 @@ func generateWordMap(conn *sqlite3.Conn, commentLimit int, verbose bool) wordCon
 		_ = stmt.Scan(&comment)
 
 		if verbose && len(comment) == 0 {
-			fmt.Printf("ERROR: Empty comment detected!\n")
+			progressf("ERROR: Empty comment detected!\n")
 		}
 
 		comments = append(comments, comment)
@@ -1163,14 +982,14 @@ func generateWordMap(conn *sqlite3.Conn, commentLimit int, verbose bool) wordCon
 		progressIteration++
 		if progressIteration%1000 == 0 && time.Since(progressTime).Seconds() > 2 {
 			progressPerSeconds := float64(progressIteration) / time.Since(progressTime).Seconds()
-			fmt.Printf("Loaded %d comments. %0.1f per sec.\n", len(comments), progressPerSeconds)
+			progressf("Loaded %d comments. %0.1f per sec.\n", len(comments), progressPerSeconds)
 
 			progressTime = time.Now()
 			progressIteration = 0
 		}
 	}
 
-	fmt.Printf("Total comments loaded: %d\n", len(comments))
+	progressf("Total comments loaded: %d\n", len(comments))
 
 	wordToId := make(map[string]int)
 	idToWord := make(map[int]string)
@@ -1229,13 +1048,13 @@ func generateWordMap(conn *sqlite3.Conn, commentLimit int, verbose bool) wordCon
 
 			progress := float64(i) / float64(totalComments) * 100.0
 
-			fmt.Printf("Analyzed %d of %d (%.01f%%)\n", i, totalComments, progress)
+			progressf("Analyzed %d of %d (%.01f%%)\n", i, totalComments, progress)
 
 			progressTime = time.Now()
 			progressIteration = 0
 		}
 
-		tokens := reFindWords.FindAllString(comments[i], -1)
+		tokens := reFindWords.FindAllString(removeCodeBlocks(comments[i]), -1)
 
 		pre1 := wordIdDot
 		pre2 := 0
@@ -1407,7 +1226,7 @@ func generateWordMap(conn *sqlite3.Conn, commentLimit int, verbose bool) wordCon
 		if progressIteration%1000 == 0 && time.Since(progressTime).Seconds() > 2 {
 			progress := float64(wordKeyIndex) / float64(len(wordMap)) * 100.0
 
-			fmt.Printf("Prepared %d word mappings (%0.1f%%)\n", wordKeyIndex, progress)
+			progressf("Prepared %d word mappings (%0.1f%%)\n", wordKeyIndex, progress)
 
 			progressTime = time.Now()
 			progressIteration = 0
@@ -1417,56 +1236,35 @@ func generateWordMap(conn *sqlite3.Conn, commentLimit int, verbose bool) wordCon
 	return outwordConfig
 }
 
-func openDatabase() *sqlite3.Conn {
-	databasePath := "hacker-bro.db"
+func queryScalar(conn *sqlite3.Conn, query string, args ...interface{}) int {
+	value, err := tryQueryScalar(conn, query, args...)
+	check(err, "Failed to run query")
 
-	fmt.Println()
-	fmt.Printf("Opening database: %s\n", databasePath)
+	return value
+}
 
-	conn, err := sqlite3.Open(databasePath)
+// tryQueryScalar is queryScalar for queries, which may fail, e.g. on bad MATCH syntax.
+func tryQueryScalar(conn *sqlite3.Conn, query string, args ...interface{}) (int, error) {
+	stmt, err := conn.Prepare(query, args...)
 	if err != nil {
-		fmt.Printf("Could not open database\n")
-		os.Exit(1)
+		return 0, err
 	}
 
-	// err = conn.Exec(
-	// 	"PRAGMA page_size = 4096;" +
-	// 		"PRAGMA cache_size=10000;" +
-	// 		"PRAGMA locking_mode=EXCLUSIVE;" +
-	// 		"PRAGMA synchronous=NORMAL;" +
-	// 		"PRAGMA journal_mode=WAL;" +
-	// 		"PRAGMA cache_size=5000;")
-
-	err = conn.Exec(
-		"PRAGMA page_size = 4096;" +
-			"PRAGMA cache_size=10000;" +
-			"PRAGMA locking_mode=EXCLUSIVE;" +
-			"PRAGMA synchronous=OFF;" +
-			"PRAGMA journal_mode=OFF;" +
-			"PRAGMA cache_size=5000;")
-	check(err, "PRAGMA failed")
-
-	return conn
-}
-
-func queryScalar(conn *sqlite3.Conn, query string) int {
-	stmt, err := conn.Prepare(query)
-	check(err, "Failed to prepare query")
-
 	defer stmt.Close()
 
 	hasRow, err := stmt.Step()
-	check(err, "Failed to step")
+	if err != nil {
+		return 0, err
+	}
 
 	if !hasRow {
-		return 0
+		return 0, nil
 	}
 
-	var storyCount int
-	err = stmt.Scan(&storyCount)
-	check(err, "Failed to scan")
+	var value int
+	err = stmt.Scan(&value)
 
-	return storyCount
+	return value, err
 }
 
 // func deleteItems(conn *sqlite3.Conn, table string) {
@@ -1485,10 +1283,23 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
+// indentText indents all lines of text, except empty ones.
+func indentText(text string, indent string) string {
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func check(err error, message string) {
 	if err != nil {
-		fmt.Printf("Error: %q\n", message)
-		fmt.Printf("Details: %q\n", err)
+		progressf("Error: %q\n", message)
+		progressf("Details: %q\n", err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTalkModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			json: `{"Words":["",".","The","sky"],"WordKeys":[{"Pre1":1,"Pre2":0,"Pre3":0},{"Pre1":2,"Pre2":1,"Pre3":0}],` +
+				`"WordMap":{"0":[2],"1":[3]},"WordScores":{"0":[5],"1":[2]}}`,
+		},
+		{
+			name:    "broken json",
+			json:    `{"Words":`,
+			wantErr: "unexpected end of JSON input",
+		},
+		{
+			name:    "no words",
+			json:    `{"Words":[]}`,
+			wantErr: "No words",
+		},
+		{
+			name:    "no word after a dot",
+			json:    `{"Words":["",".","a","b"],"WordKeys":[{"Pre1":2,"Pre2":0,"Pre3":0}],"WordMap":{"0":[3]},"WordScores":{"0":[1]}}`,
+			wantErr: "No word follows a dot",
+		},
+		{
+			name:    "key without next words",
+			json:    `{"Words":["",".","a"],"WordKeys":[{"Pre1":1,"Pre2":0,"Pre3":0}],"WordMap":{},"WordScores":{}}`,
+			wantErr: "No next words for key 0",
+		},
+		{
+			name:    "unknown next word",
+			json:    `{"Words":["",".","a"],"WordKeys":[{"Pre1":1,"Pre2":0,"Pre3":0}],"WordMap":{"0":[7]},"WordScores":{"0":[1]}}`,
+			wantErr: "Unknown word 7",
+		},
+		{
+			name:    "unknown key word",
+			json:    `{"Words":["",".","a"],"WordKeys":[{"Pre1":1,"Pre2":9,"Pre3":0}],"WordMap":{"0":[2]},"WordScores":{"0":[1]}}`,
+			wantErr: "Unknown word 9 in key 0",
+		},
+		{
+			name:    "missing scores",
+			json:    `{"Words":["",".","a"],"WordKeys":[{"Pre1":1,"Pre2":0,"Pre3":0}],"WordMap":{"0":[2]},"WordScores":{}}`,
+			wantErr: "Scores of key 0 don't match",
+		},
+		{
+			name:    "zero score",
+			json:    `{"Words":["",".","a"],"WordKeys":[{"Pre1":1,"Pre2":0,"Pre3":0}],"WordMap":{"0":[2]},"WordScores":{"0":[0]}}`,
+			wantErr: "Score 0 of key 0",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "talk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("%d.json", i))
+
+			err := ioutil.WriteFile(path, []byte(test.json), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = loadTalkModel(path)
+
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Error %v, expected %q", err, test.wantErr)
+			}
+		})
+	}
+}
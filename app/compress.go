@@ -0,0 +1,142 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	compressionNone  = ""
+	compressionGzip  = "gzip"
+	compressionBzip2 = "bzip2"
+	compressionZstd  = "zstd"
+	compressionXz    = "xz"
+)
+
+var compressionMagics = []struct {
+	compression string
+	magic       []byte
+}{
+	{compressionGzip, []byte{0x1f, 0x8b}},
+	{compressionBzip2, []byte("BZh")},
+	{compressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{compressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+var compressionExtensions = map[string]string{
+	".gz":   compressionGzip,
+	".gzip": compressionGzip,
+	".bz2":  compressionBzip2,
+	".zst":  compressionZstd,
+	".zstd": compressionZstd,
+	".xz":   compressionXz,
+}
+
+// openInput opens a file for reading. Compressed files are decompressed on the fly.
+func openInput(filePath string) (io.ReadCloser, error) {
+	openFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := newDecompressReader(openFile, filepath.Base(filePath))
+	if err != nil {
+		openFile.Close()
+		return nil, err
+	}
+
+	return &multiCloser{reader, []io.Closer{reader, openFile}}, nil
+}
+
+// newDecompressReader detects the compression of a stream by its magic bytes
+// and falls back to the extension of name, if no magic bytes match.
+func newDecompressReader(input io.Reader, name string) (io.ReadCloser, error) {
+	bufferedInput := bufio.NewReaderSize(input, 64*1024)
+
+	compression := detectCompression(bufferedInput, name)
+
+	switch compression {
+	case compressionGzip:
+		return gzip.NewReader(bufferedInput)
+
+	case compressionBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(bufferedInput)), nil
+
+	case compressionZstd:
+		decoder, err := zstd.NewReader(bufferedInput)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReadCloser{decoder}, nil
+
+	case compressionXz:
+		reader, err := xz.NewReader(bufferedInput)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(reader), nil
+	}
+
+	return ioutil.NopCloser(bufferedInput), nil
+}
+
+func detectCompression(input *bufio.Reader, name string) string {
+	header, _ := input.Peek(8)
+
+	for _, entry := range compressionMagics {
+		if bytes.HasPrefix(header, entry.magic) {
+			return entry.compression
+		}
+	}
+
+	// Let the decompressor complain about a broken header, instead of
+	// feeding compressed garbage into the Json parser.
+	if len(header) > 0 {
+		if compression, hasKey := compressionExtensions[strings.ToLower(filepath.Ext(name))]; hasKey {
+			return compression
+		}
+	}
+
+	return compressionNone
+}
+
+type zstdReadCloser struct {
+	decoder *zstd.Decoder
+}
+
+func (reader *zstdReadCloser) Read(p []byte) (int, error) {
+	return reader.decoder.Read(p)
+}
+
+func (reader *zstdReadCloser) Close() error {
+	reader.decoder.Close()
+	return nil
+}
+
+// multiCloser reads from reader and closes all closers in order.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (reader *multiCloser) Close() error {
+	var firstErr error
+
+	for _, closer := range reader.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+const defaultDatabasePath = "hacker-bro.db"
+
+// DatabasePath is the database used by all commands. Set it with DatabasePathFor.
+var DatabasePath = defaultDatabasePath
+
+// Durability modes
+const (
+	// No journal, no fsync and an exclusive lock. Fastest for bulk imports,
+	// but a crash can corrupt the database.
+	DurabilityFast = "fast"
+
+	// Rollback journal with full fsync. Readers wait for writers.
+	DurabilitySafe = "safe"
+
+	// Write-ahead log. Readers see the last commit while an import runs.
+	DurabilityWal = "wal"
+)
+
+var durabilityModes = []string{DurabilityFast, DurabilitySafe, DurabilityWal}
+
+// Durability is the durability mode of the database connection.
+var Durability = DurabilityWal
+
+// How long to wait for the lock of another process
+const databaseBusyTimeout = 30 * time.Second
+
+// DatabasePathFor resolves the -db flag. A plain name like "2019" selects a
+// named database next to the default one (hacker-bro-2019.db), anything with
+// a directory or file extension is used as path.
+func DatabasePathFor(value string) string {
+	if value == "" {
+		return defaultDatabasePath
+	}
+
+	if strings.ContainsRune(value, filepath.Separator) || strings.ContainsRune(value, '/') || filepath.Ext(value) != "" {
+		return value
+	}
+
+	return strings.TrimSuffix(defaultDatabasePath, ".db") + "-" + value + ".db"
+}
+
+// ParseDurability validates the -durability flag.
+func ParseDurability(mode string) string {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+
+	for _, knownMode := range durabilityModes {
+		if mode == knownMode {
+			return mode
+		}
+	}
+
+	progressf("Unknown durability [%s]. Use one of: %s\n", mode, strings.Join(durabilityModes, ", "))
+	os.Exit(1)
+
+	return ""
+}
+
+func openDatabase() *sqlite3.Conn {
+	checkDatabaseExists()
+
+	conn := connectDatabase(true)
+
+	err := conn.Exec("PRAGMA cache_size=10000")
+	check(err, "PRAGMA failed")
+
+	return conn
+}
+
+// connectDatabase opens the database in the Durability mode. With migrate,
+// pending schema migrations are applied first.
+func connectDatabase(migrate bool) *sqlite3.Conn {
+	progressf("\n")
+	progressf("Opening database: %s\n", DatabasePath)
+
+	conn, err := sqlite3.Open(DatabasePath)
+	if err != nil {
+		progressf("Could not open database\n")
+		os.Exit(1)
+	}
+
+	conn.BusyTimeout(databaseBusyTimeout)
+
+	setDurability(conn, Durability)
+
+	if migrate {
+		migrateDatabase(conn, false)
+	}
+
+	return conn
+}
+
+// checkDatabaseExists stops commands, which only work on existing data.
+// Otherwise a mistyped -db would silently create an empty database.
+func checkDatabaseExists() {
+	if !fileExists(DatabasePath) {
+		progressf("\n")
+		progressf("Database [%s] doesn't exist. Import some files first or choose another database with -db.\n", DatabasePath)
+		os.Exit(1)
+	}
+}
+
+func setDurability(conn *sqlite3.Conn, mode string) {
+	var pragmas string
+
+	switch mode {
+	case DurabilityFast:
+		pragmas = "PRAGMA locking_mode=EXCLUSIVE;" +
+			"PRAGMA synchronous=OFF;" +
+			"PRAGMA journal_mode=OFF;"
+
+	case DurabilitySafe:
+		pragmas = "PRAGMA locking_mode=NORMAL;" +
+			"PRAGMA synchronous=FULL;" +
+			"PRAGMA journal_mode=DELETE;"
+
+	case DurabilityWal:
+		// NORMAL is durable enough in WAL mode. A crash can only lose the last commits.
+		pragmas = "PRAGMA locking_mode=NORMAL;" +
+			"PRAGMA synchronous=NORMAL;" +
+			"PRAGMA journal_mode=WAL;"
+	}
+
+	err := conn.Exec(pragmas)
+	check(err, "PRAGMA failed")
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Number of lines handed to a decode worker at once
+const decodeChunkSize = 512
+
+type decodedLine struct {
+	lineNumber int
+	line       []byte
+	item       item
+	err        error
+}
+
+type decodeChunk struct {
+	lines   []decodedLine
+	readErr error
+	done    chan struct{}
+}
+
+// decodeLines reads newline delimited Json items and calls handle for every
+// line in input order. With more than one worker, lines are unmarshalled
+// concurrently in chunks, while handle still runs on the calling goroutine.
+func decodeLines(reader *bufio.Reader, workers int, handle func(decoded *decodedLine)) error {
+	if workers <= 1 {
+		for lineNumber := 1; ; lineNumber++ {
+			line, err := readLine(reader)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			decoded := decodedLine{lineNumber: lineNumber, line: line}
+			decoded.err = json.Unmarshal(line, &decoded.item)
+
+			handle(&decoded)
+		}
+	}
+
+	jobs := make(chan *decodeChunk, workers)
+
+	// Chunks in input order. The buffer limits the number of chunks in memory.
+	ordered := make(chan *decodeChunk, workers*2)
+
+	var waitGroup sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			for chunk := range jobs {
+				for i := range chunk.lines {
+					decoded := &chunk.lines[i]
+					decoded.err = json.Unmarshal(decoded.line, &decoded.item)
+				}
+
+				close(chunk.done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+
+		lineNumber := 0
+
+		for {
+			chunk := &decodeChunk{
+				lines: make([]decodedLine, 0, decodeChunkSize),
+				done:  make(chan struct{}),
+			}
+
+			for len(chunk.lines) < decodeChunkSize {
+				line, err := readLine(reader)
+				if err != nil {
+					if err != io.EOF {
+						chunk.readErr = err
+					}
+					break
+				}
+
+				lineNumber++
+				chunk.lines = append(chunk.lines, decodedLine{lineNumber: lineNumber, line: line})
+			}
+
+			ordered <- chunk
+			jobs <- chunk
+
+			if len(chunk.lines) < decodeChunkSize {
+				return
+			}
+		}
+	}()
+
+	var readErr error
+
+	for chunk := range ordered {
+		<-chunk.done
+
+		for i := range chunk.lines {
+			handle(&chunk.lines[i])
+		}
+
+		if chunk.readErr != nil {
+			readErr = chunk.readErr
+		}
+	}
+
+	waitGroup.Wait()
+
+	return readErr
+}
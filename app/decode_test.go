@@ -0,0 +1,110 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// decodeTestInput returns lineCount comment items, one per line, with the line number as id.
+func decodeTestInput(lineCount int) []byte {
+	var input bytes.Buffer
+
+	for id := 1; id <= lineCount; id++ {
+		fmt.Fprintf(&input,
+			`{"by":"user%d","id":%d,"parent":%d,"text":"Comment %d with &quot;some&quot; text, a <a href=\"https://example.com/%d\">link</a> and more words to decode.","time":1570000000,"type":"comment"}`+"\n",
+			id%97, id, id/2+1, id, id)
+	}
+
+	return input.Bytes()
+}
+
+func TestDecodeLinesKeepsOrder(t *testing.T) {
+	lineCounts := []int{0, 1, decodeChunkSize - 1, decodeChunkSize, decodeChunkSize + 1, decodeChunkSize * 3, decodeChunkSize*3 + 17}
+
+	for _, lineCount := range lineCounts {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%d lines, %d workers", lineCount, workers), func(t *testing.T) {
+				reader := bufio.NewReader(bytes.NewReader(decodeTestInput(lineCount)))
+
+				handled := 0
+
+				err := decodeLines(reader, workers, func(decoded *decodedLine) {
+					handled++
+
+					if decoded.lineNumber != handled {
+						t.Fatalf("Line number %d, expected %d", decoded.lineNumber, handled)
+					}
+
+					if decoded.err != nil {
+						t.Fatalf("Line %d: %s", decoded.lineNumber, decoded.err)
+					}
+
+					if decoded.item.Id != handled {
+						t.Fatalf("Line %d has item %d", decoded.lineNumber, decoded.item.Id)
+					}
+
+					if !bytes.Contains(decoded.line, []byte(fmt.Sprintf(`"id":%d,`, handled))) {
+						t.Fatalf("Line %d has the content of another line: %s", decoded.lineNumber, decoded.line)
+					}
+				})
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if handled != lineCount {
+					t.Fatalf("Handled %d lines, expected %d", handled, lineCount)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeLinesReportsBadLines(t *testing.T) {
+	input := string(decodeTestInput(decodeChunkSize)) + "{broken\n" + string(decodeTestInput(1))
+
+	for _, workers := range []int{1, 4} {
+		var badLines []int
+
+		err := decodeLines(bufio.NewReader(strings.NewReader(input)), workers, func(decoded *decodedLine) {
+			if decoded.err != nil {
+				badLines = append(badLines, decoded.lineNumber)
+			}
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(badLines) != 1 || badLines[0] != decodeChunkSize+1 {
+			t.Fatalf("%d workers: bad lines %v, expected [%d]", workers, badLines, decodeChunkSize+1)
+		}
+	}
+}
+
+// BenchmarkDecodeLines compares the sequential loop of Import (workers=1) with a worker per CPU.
+func BenchmarkDecodeLines(b *testing.B) {
+	input := decodeTestInput(20000)
+
+	workerCounts := []int{1}
+	if runtime.NumCPU() > 1 {
+		workerCounts = append(workerCounts, runtime.NumCPU())
+	}
+
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+
+			for i := 0; i < b.N; i++ {
+				err := decodeLines(bufio.NewReader(bytes.NewReader(input)), workers, func(decoded *decodedLine) {})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
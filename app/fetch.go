@@ -0,0 +1,528 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultFetchBaseUrl = "https://hacker-news.firebaseio.com/v0"
+
+// Number of fetched items after which the checkpoint is saved
+const fetchCheckpointInterval = 1000
+
+// FetchOptions configures Fetch.
+type FetchOptions struct {
+	// The API root, e.g. a local stub server for testing. Defaults to the HN Firebase API.
+	BaseUrl string
+
+	// Item ids are fetched from From down to To. From defaults to maxitem, To to 1.
+	From int
+	To   int
+
+	// Maximum number of ids to walk. 0 means no limit.
+	Count int
+
+	Concurrency int
+
+	// Attempts per item after the first one. Waits twice as long after each attempt.
+	Retries int
+
+	// Line delimited Json file, which can be imported later. Items are inserted
+	// into the database directly, if empty.
+	OutputPath string
+
+	// Name stored in the File column, when inserting directly
+	Source string
+
+	// The next id is saved here, so an interrupted fetch can be resumed.
+	CheckpointPath string
+}
+
+// Kinds of fetch output
+const (
+	fetchSinkFile     = "file"
+	fetchSinkDatabase = "database"
+)
+
+// fetchCheckpoint is the progress of a fetch. All ids above Next are done.
+type fetchCheckpoint struct {
+	Next int `json:"next"`
+	To   int `json:"to"`
+
+	// Where the items went. A checkpoint only resumes a fetch into the same output.
+	Sink   string `json:"sink"`
+	Output string `json:"output"`
+
+	// Size of the output file at Next. Anything after it is written again.
+	Offset int64 `json:"offset"`
+}
+
+type fetchJob struct {
+	id   int
+	body []byte
+	err  error
+	done chan struct{}
+}
+
+type fetcher struct {
+	baseUrl string
+	client  *http.Client
+	retries int
+}
+
+// Fetch downloads items from the HN API and writes them to a file or imports them.
+func Fetch(options FetchOptions) {
+	fetcher := &fetcher{
+		baseUrl: strings.TrimRight(options.BaseUrl, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+		retries: options.Retries,
+	}
+
+	if fetcher.baseUrl == "" {
+		fetcher.baseUrl = defaultFetchBaseUrl
+	}
+
+	from := options.From
+	to := options.To
+
+	if to <= 0 {
+		to = 1
+	}
+
+	if from <= 0 {
+		maxItem, err := fetcher.fetchMaxItem()
+		check(err, "Failed to fetch maxitem")
+
+		progressf("Max item: %d\n", maxItem)
+		from = maxItem
+	}
+
+	newCheckpoint := fetchCheckpoint{To: to, Sink: fetchSinkDatabase, Output: DatabasePath}
+	if options.OutputPath != "" {
+		newCheckpoint.Sink = fetchSinkFile
+		newCheckpoint.Output = options.OutputPath
+	}
+
+	// Paths are compared absolute, so a checkpoint works from another directory
+	outputPath, err := filepath.Abs(newCheckpoint.Output)
+	check(err, "Failed to get absolute path")
+
+	newCheckpoint.Output = outputPath
+
+	resumeOffset := int64(-1)
+
+	if checkpoint, hasCheckpoint := loadFetchCheckpoint(options.CheckpointPath); hasCheckpoint {
+		if checkpoint.To == to && checkpoint.Sink == newCheckpoint.Sink && checkpoint.Output == newCheckpoint.Output {
+			progressf("Resuming at item %d from [%s]\n", checkpoint.Next, options.CheckpointPath)
+			from = checkpoint.Next
+			resumeOffset = checkpoint.Offset
+		} else {
+			progressf("Ignoring checkpoint [%s] of another fetch into [%s]\n", options.CheckpointPath, checkpoint.Output)
+		}
+	}
+
+	saveCheckpoint := func(next int, offset int64) {
+		checkpoint := newCheckpoint
+		checkpoint.Next = next
+		checkpoint.Offset = offset
+
+		saveFetchCheckpoint(options.CheckpointPath, checkpoint)
+	}
+
+	// Last id of this run. With a count, the checkpoint is kept for the next run.
+	lastId := to
+
+	if options.Count > 0 && from-options.Count+1 > lastId {
+		lastId = from - options.Count + 1
+	}
+
+	if from < lastId {
+		progressf("Nothing to fetch\n")
+		return
+	}
+
+	progressf("Fetching items %d down to %d from [%s]\n", from, lastId, fetcher.baseUrl)
+
+	var sink fetchSink
+
+	if options.OutputPath != "" {
+		sink = newFileFetchSink(options.OutputPath, resumeOffset)
+	} else {
+		sink = newDatabaseFetchSink(options.Source)
+	}
+
+	// Even the first items can be resumed, e.g. after the process was killed
+	saveCheckpoint(from, sink.flush())
+
+	progressTime := time.Now()
+	progressIteration := 0
+	fetchedCounter := 0
+	missingCounter := 0
+
+	fetcher.fetchRange(from, lastId, options.Concurrency, func(job *fetchJob) {
+		if job.err != nil {
+			progressf("Failed to fetch item %d: %s\n", job.id, job.err)
+
+			// Everything after the checkpoint is fetched again, when resuming
+			sink.abort()
+			os.Exit(1)
+		}
+
+		if bytes.Equal(bytes.TrimSpace(job.body), []byte("null")) {
+			missingCounter++
+		} else {
+			sink.add(job.id, job.body)
+			fetchedCounter++
+		}
+
+		if (from-job.id+1)%fetchCheckpointInterval == 0 {
+			offset := sink.flush()
+			saveCheckpoint(job.id-1, offset)
+		}
+
+		progressIteration++
+		if progressIteration%100 == 0 && time.Since(progressTime).Seconds() > 2 {
+
+			progressPerSeconds := float64(progressIteration) / time.Since(progressTime).Seconds()
+
+			progressf("Fetched %d items, now at %d / %0.1f items per sec\n", fetchedCounter, job.id, progressPerSeconds)
+
+			progressTime = time.Now()
+			progressIteration = 0
+		}
+	})
+
+	offset := sink.flush()
+	sink.close()
+
+	if lastId > to {
+		saveCheckpoint(lastId-1, offset)
+	} else if fileExists(options.CheckpointPath) {
+		// Completed. A new fetch starts over.
+		err := os.Remove(options.CheckpointPath)
+		check(err, "Failed to remove checkpoint")
+	}
+
+	progressf("Fetched %d items. %d ids had no item.\n", fetchedCounter, missingCounter)
+}
+
+// fetchRange fetches the ids from..to concurrently and calls handle for every
+// id in descending order on the calling goroutine.
+func (fetcher *fetcher) fetchRange(from int, to int, concurrency int, handle func(job *fetchJob)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan *fetchJob, concurrency)
+
+	// Jobs in id order. The buffer limits how far workers run ahead.
+	ordered := make(chan *fetchJob, concurrency*4)
+
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for job := range jobs {
+				job.body, job.err = fetcher.fetchItem(job.id)
+				close(job.done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+
+		for id := from; id >= to; id-- {
+			job := &fetchJob{id: id, done: make(chan struct{})}
+
+			ordered <- job
+			jobs <- job
+		}
+	}()
+
+	for job := range ordered {
+		<-job.done
+		handle(job)
+	}
+}
+
+func (fetcher *fetcher) fetchMaxItem() (int, error) {
+	body, err := fetcher.get(fetcher.baseUrl + "/maxitem.json")
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(body)))
+}
+
+func (fetcher *fetcher) fetchItem(id int) ([]byte, error) {
+	return fetcher.get(fmt.Sprintf("%s/item/%d.json", fetcher.baseUrl, id))
+}
+
+// get retries network errors, rate limits and server errors with exponential backoff.
+func (fetcher *fetcher) get(url string) ([]byte, error) {
+	backoff := 500 * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		body, retry, err := fetcher.getOnce(url)
+
+		if err == nil {
+			return body, nil
+		}
+
+		if !retry || attempt >= fetcher.retries {
+			return nil, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (fetcher *fetcher) getOnce(url string) ([]byte, bool, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	request.Header.Set("User-Agent", "hacker-bro")
+
+	response, err := fetcher.client.Do(request)
+	if err != nil {
+		return nil, true, err
+	}
+
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return nil, true, fmt.Errorf("%s: %s", url, response.Status)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("%s: %s", url, response.Status)
+	}
+
+	return body, false, nil
+}
+
+func loadFetchCheckpoint(checkpointPath string) (fetchCheckpoint, bool) {
+	var checkpoint fetchCheckpoint
+
+	if !fileExists(checkpointPath) {
+		return checkpoint, false
+	}
+
+	file, err := ioutil.ReadFile(checkpointPath)
+	check(err, "Failed to read checkpoint")
+
+	err = json.Unmarshal(file, &checkpoint)
+	check(err, "Failed to parse checkpoint")
+
+	return checkpoint, true
+}
+
+func saveFetchCheckpoint(checkpointPath string, checkpoint fetchCheckpoint) {
+	jsonString, err := json.Marshal(checkpoint)
+	check(err, "Failed to serialize checkpoint")
+
+	// Replaced in one step, so an interrupted write can't leave a broken checkpoint
+	err = ioutil.WriteFile(checkpointPath+".tmp", jsonString, 0644)
+	check(err, "Failed to write checkpoint")
+
+	err = os.Rename(checkpointPath+".tmp", checkpointPath)
+	check(err, "Failed to write checkpoint")
+}
+
+// fetchSink receives the fetched items in order. flush is called before a
+// checkpoint is saved, so everything before it is stored. It returns the
+// size of the output file, if there is one. abort drops everything added
+// since the last flush.
+type fetchSink interface {
+	add(id int, body []byte)
+	flush() int64
+	abort()
+	close()
+}
+
+// fileFetchSink appends items to a line delimited Json file.
+type fileFetchSink struct {
+	file   *os.File
+	writer *bufio.Writer
+
+	// Size of the file after the last flush. The buffer may have written
+	// parts of later lines already.
+	flushedOffset int64
+}
+
+// newFileFetchSink opens the output file. When resuming, lines written after
+// the checkpoint at resumeOffset are cut off. A negative offset keeps the file.
+func newFileFetchSink(outputPath string, resumeOffset int64) *fileFetchSink {
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	check(err, "Failed to open output file")
+
+	info, err := file.Stat()
+	check(err, "Failed to open output file")
+
+	sink := &fileFetchSink{file: file, writer: bufio.NewWriter(file), flushedOffset: info.Size()}
+
+	if resumeOffset > info.Size() {
+		progressf("[%s] is shorter than at the checkpoint. Remove the checkpoint to start over.\n", outputPath)
+		os.Exit(1)
+	}
+
+	if resumeOffset >= 0 && resumeOffset < info.Size() {
+		progressf("Dropping %d bytes after the checkpoint from [%s]\n", info.Size()-resumeOffset, outputPath)
+
+		sink.flushedOffset = resumeOffset
+		sink.truncate()
+	}
+
+	progressf("Writing items to [%s]\n", outputPath)
+
+	return sink
+}
+
+func (sink *fileFetchSink) add(id int, body []byte) {
+	var line bytes.Buffer
+
+	err := json.Compact(&line, body)
+	if err != nil {
+		progressf("Invalid Json for item %d: %s\n", id, err)
+		os.Exit(1)
+	}
+
+	line.WriteByte('\n')
+
+	_, err = sink.writer.Write(line.Bytes())
+	check(err, "Failed to write output file")
+}
+
+func (sink *fileFetchSink) flush() int64 {
+	err := sink.writer.Flush()
+	check(err, "Failed to write output file")
+
+	err = sink.file.Sync()
+	check(err, "Failed to write output file")
+
+	info, err := sink.file.Stat()
+	check(err, "Failed to write output file")
+
+	sink.flushedOffset = info.Size()
+
+	return sink.flushedOffset
+}
+
+func (sink *fileFetchSink) abort() {
+	sink.writer.Reset(sink.file)
+	sink.truncate()
+
+	err := sink.file.Close()
+	check(err, "Failed to close output file")
+}
+
+// truncate cuts the file after the last complete line of the last flush.
+func (sink *fileFetchSink) truncate() {
+	err := sink.file.Truncate(sink.flushedOffset)
+	check(err, "Failed to truncate output file")
+
+	err = sink.file.Sync()
+	check(err, "Failed to truncate output file")
+}
+
+func (sink *fileFetchSink) close() {
+	sink.flush()
+
+	err := sink.file.Close()
+	check(err, "Failed to close output file")
+}
+
+// databaseFetchSink inserts items like Import does.
+type databaseFetchSink struct {
+	imp    *importer
+	source string
+}
+
+func newDatabaseFetchSink(source string) *databaseFetchSink {
+	conn := connectDatabase(true)
+
+	createImportTempTables(conn)
+
+	imp := newImporter(conn)
+
+	err := conn.Begin()
+	check(err, "Failed to start transaction")
+
+	return &databaseFetchSink{imp: imp, source: source}
+}
+
+func (sink *databaseFetchSink) add(id int, body []byte) {
+	var fetchedItem item
+
+	err := json.Unmarshal(body, &fetchedItem)
+	if err != nil {
+		progressf("Invalid Json for item %d: %s\n", id, err)
+		os.Exit(1)
+	}
+
+	fetchedItem.fileName = sink.source
+
+	if err := sink.imp.importItem(fetchedItem); err != nil {
+		progressf("Skipping item %d: %s\n", id, err)
+	}
+}
+
+func (sink *databaseFetchSink) flush() int64 {
+	conn := sink.imp.conn
+
+	err := conn.Commit()
+	check(err, "Failed to commit transaction")
+
+	err = conn.Begin()
+	check(err, "Failed to start transaction")
+
+	sink.imp.pendingItems = 0
+
+	return 0
+}
+
+func (sink *databaseFetchSink) abort() {
+	conn := sink.imp.conn
+
+	err := conn.Rollback()
+	check(err, "Failed to rollback transaction")
+
+	// The database can't be closed with prepared statements left
+	sink.imp.close()
+
+	err = conn.Close()
+	check(err, "Failed to close database")
+}
+
+func (sink *databaseFetchSink) close() {
+	conn := sink.imp.conn
+
+	err := conn.Commit()
+	check(err, "Failed to commit transaction")
+
+	sink.imp.counters.print()
+
+	resolveComments(conn)
+
+	sink.imp.close()
+
+	err = conn.Close()
+	check(err, "Failed to close database")
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fetchStub is a HN API with items 1..maxItem. Item 7 is missing and item 12
+// fails once with 503.
+type fetchStub struct {
+	maxItem int
+
+	mutex    sync.Mutex
+	requests map[int]int
+}
+
+func newFetchStub(maxItem int) (*fetchStub, *httptest.Server) {
+	stub := &fetchStub{maxItem: maxItem, requests: make(map[int]int)}
+
+	return stub, httptest.NewServer(stub)
+}
+
+func (stub *fetchStub) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == "/maxitem.json" {
+		fmt.Fprintf(writer, "%d", stub.maxItem)
+		return
+	}
+
+	idText := strings.TrimSuffix(strings.TrimPrefix(request.URL.Path, "/item/"), ".json")
+
+	id, err := strconv.Atoi(idText)
+	if err != nil || id < 1 || id > stub.maxItem {
+		http.NotFound(writer, request)
+		return
+	}
+
+	stub.mutex.Lock()
+	stub.requests[id]++
+	requestCount := stub.requests[id]
+	stub.mutex.Unlock()
+
+	switch {
+	case id == 7:
+		fmt.Fprint(writer, "null")
+
+	case id == 12 && requestCount == 1:
+		http.Error(writer, "busy", http.StatusServiceUnavailable)
+
+	default:
+		// Indented like the real API, fetch writes a line per item
+		fmt.Fprintf(writer, "{\n \"by\": \"user\",\n \"id\": %d,\n \"parent\": 1,\n \"text\": \"Comment %d\",\n \"type\": \"comment\"\n}", id, id)
+	}
+}
+
+func (stub *fetchStub) requestCount(id int) int {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+
+	return stub.requests[id]
+}
+
+// readFetchedIds returns the ids of a fetch output file and fails on broken lines.
+func readFetchedIds(t *testing.T, outputPath string) []int {
+	file, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	var ids []int
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var fetchedItem item
+
+		if err := json.Unmarshal(scanner.Bytes(), &fetchedItem); err != nil {
+			t.Fatalf("Broken line %d [%s]: %s", len(ids)+1, scanner.Text(), err)
+		}
+
+		ids = append(ids, fetchedItem.Id)
+	}
+
+	return ids
+}
+
+// expectedFetchIds are the ids from..to of the stub without the missing item 7.
+func expectedFetchIds(from int, to int) []int {
+	var ids []int
+
+	for id := from; id >= to; id-- {
+		if id != 7 {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func checkFetchedIds(t *testing.T, ids []int, expectedIds []int) {
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIds) {
+		t.Fatalf("Fetched ids\n got: %v\nwant: %v", ids, expectedIds)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	stub, server := newFetchStub(30)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	options := FetchOptions{
+		BaseUrl:        server.URL,
+		To:             1,
+		Concurrency:    4,
+		Retries:        2,
+		OutputPath:     filepath.Join(dir, "items.json"),
+		CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+	}
+
+	Fetch(options)
+
+	checkFetchedIds(t, readFetchedIds(t, options.OutputPath), expectedFetchIds(30, 1))
+
+	if stub.requestCount(12) != 2 {
+		t.Errorf("Item 12 was requested %d times, expected a retry after 503", stub.requestCount(12))
+	}
+
+	if fileExists(options.CheckpointPath) {
+		t.Errorf("Checkpoint of a completed fetch wasn't removed")
+	}
+}
+
+func TestFetchResumesFromCheckpoint(t *testing.T) {
+	stub, server := newFetchStub(30)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	options := FetchOptions{
+		BaseUrl:        server.URL,
+		From:           30,
+		To:             1,
+		Count:          10,
+		Concurrency:    4,
+		Retries:        2,
+		OutputPath:     filepath.Join(dir, "items.json"),
+		CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+	}
+
+	Fetch(options)
+
+	checkFetchedIds(t, readFetchedIds(t, options.OutputPath), expectedFetchIds(30, 21))
+
+	checkpoint, hasCheckpoint := loadFetchCheckpoint(options.CheckpointPath)
+	if !hasCheckpoint || checkpoint.Next != 20 || checkpoint.To != 1 {
+		t.Fatalf("Checkpoint %+v, expected next 20 to 1", checkpoint)
+	}
+
+	// A killed fetch leaves a half written line after the checkpoint
+	file, err := os.OpenFile(options.OutputPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Fprint(file, "{\"by\":\"user\",\"id\":20,\"par")
+	file.Close()
+
+	options.Count = 0
+	Fetch(options)
+
+	checkFetchedIds(t, readFetchedIds(t, options.OutputPath), expectedFetchIds(30, 1))
+
+	for id := 21; id <= 30; id++ {
+		if stub.requestCount(id) != 1 {
+			t.Errorf("Item %d was requested %d times, expected once", id, stub.requestCount(id))
+		}
+	}
+}
+
+func TestFileFetchSinkAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "items.json")
+
+	sink := newFileFetchSink(outputPath, -1)
+
+	for id := 100; id > 90; id-- {
+		sink.add(id, []byte(fmt.Sprintf(`{"id": %d, "type": "comment"}`, id)))
+	}
+
+	offset := sink.flush()
+
+	// More than the buffer, so parts of these lines are written before the failure
+	for id := 90; id > 0; id-- {
+		sink.add(id, []byte(fmt.Sprintf(`{"id": %d, "type": "comment", "text": "%s"}`, id, strings.Repeat("x", 200))))
+	}
+
+	sink.abort()
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != offset {
+		t.Errorf("Size after abort %d, expected %d", info.Size(), offset)
+	}
+
+	checkFetchedIds(t, readFetchedIds(t, outputPath), []int{100, 99, 98, 97, 96, 95, 94, 93, 92, 91})
+}
+
+func TestFetchIgnoresCheckpointOfOtherOutput(t *testing.T) {
+	_, server := newFetchStub(30)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	options := FetchOptions{
+		BaseUrl:        server.URL,
+		From:           5,
+		To:             1,
+		Concurrency:    2,
+		OutputPath:     filepath.Join(dir, "items.json"),
+		CheckpointPath: filepath.Join(dir, "checkpoint.json"),
+	}
+
+	err = ioutil.WriteFile(options.OutputPath, []byte(`{"id":100,"type":"comment"}`+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Left by a fetch into the database
+	saveFetchCheckpoint(options.CheckpointPath, fetchCheckpoint{Next: 3, To: 1, Sink: fetchSinkDatabase, Output: filepath.Join(dir, "hacker-bro.db")})
+
+	Fetch(options)
+
+	checkFetchedIds(t, readFetchedIds(t, options.OutputPath), []int{100, 5, 4, 3, 2, 1})
+}
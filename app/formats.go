@@ -0,0 +1,376 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Input formats of Import
+const (
+	FormatAuto     = "auto"
+	FormatHn       = "hn"
+	FormatAlgolia  = "algolia"
+	FormatBigQuery = "bigquery"
+)
+
+var inputFormats = []string{FormatAuto, FormatHn, FormatAlgolia, FormatBigQuery}
+
+// itemDecoder reads all items of an input and calls handle for each of them in input order.
+type itemDecoder func(reader *bufio.Reader, workers int, handle func(decoded *decodedLine)) error
+
+var itemDecoders = map[string]itemDecoder{
+	FormatHn:       decodeLines,
+	FormatAlgolia:  decodeAlgolia,
+	FormatBigQuery: decodeBigQuery,
+}
+
+// ParseInputFormat validates the -format flag.
+func ParseInputFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+
+	for _, knownFormat := range inputFormats {
+		if format == knownFormat {
+			return format
+		}
+	}
+
+	progressf("Unknown format [%s]. Use one of: %s\n", format, strings.Join(inputFormats, ", "))
+	os.Exit(1)
+
+	return ""
+}
+
+// detectInputFormat looks at the start of the decompressed input. Json
+// arrays and objects with Algolia fields are Algolia hits and a CSV header
+// with an id column is a BigQuery export. Everything else is read as HN items
+// per line, so bad lines end up in the quarantine.
+func detectInputFormat(reader *bufio.Reader) string {
+	start, _ := reader.Peek(4096)
+	start = bytes.TrimLeft(start, " \t\r\n\ufeff")
+
+	if len(start) == 0 {
+		return FormatHn
+	}
+
+	if start[0] == '[' {
+		return FormatAlgolia
+	}
+
+	if start[0] == '{' {
+		if bytes.Contains(start, []byte(`"objectID"`)) || bytes.Contains(start, []byte(`"hits"`)) {
+			return FormatAlgolia
+		}
+
+		return FormatHn
+	}
+
+	headerLine := start
+	if end := bytes.IndexByte(start, '\n'); end >= 0 {
+		headerLine = start[:end]
+	}
+
+	header, err := csv.NewReader(bytes.NewReader(headerLine)).Read()
+	if err == nil && bigQueryHeaderColumns(header)["id"] != nil {
+		return FormatBigQuery
+	}
+
+	return FormatHn
+}
+
+// algoliaHit is an item of the Algolia HN Search API.
+type algoliaHit struct {
+	ObjectId    string   `json:"objectID"`
+	Tags        []string `json:"_tags"`
+	Author      string   `json:"author"`
+	CreatedAtI  int64    `json:"created_at_i"`
+	Title       string   `json:"title"`
+	Url         string   `json:"url"`
+	StoryText   string   `json:"story_text"`
+	CommentText string   `json:"comment_text"`
+	Points      int      `json:"points"`
+	NumComments int      `json:"num_comments"`
+	ParentId    int      `json:"parent_id"`
+}
+
+// decodeAlgolia reads search responses with a hits array, plain arrays of
+// hits or single hits. Several of them may follow each other, e.g. one per line.
+// Line numbers are the position of the hit in the input.
+func decodeAlgolia(reader *bufio.Reader, workers int, handle func(decoded *decodedLine)) error {
+	decoder := json.NewDecoder(reader)
+	hitNumber := 0
+
+	handleHit := func(rawHit json.RawMessage) {
+		hitNumber++
+
+		decoded := decodedLine{lineNumber: hitNumber, line: rawHit}
+
+		var hit algoliaHit
+		decoded.err = json.Unmarshal(rawHit, &hit)
+
+		if decoded.err == nil {
+			decoded.item, decoded.err = hit.toItem()
+		}
+
+		handle(&decoded)
+	}
+
+	for {
+		var value json.RawMessage
+
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		value = bytes.TrimSpace(value)
+
+		if len(value) > 0 && value[0] == '[' {
+			var hits []json.RawMessage
+
+			if err := json.Unmarshal(value, &hits); err != nil {
+				return err
+			}
+
+			for _, rawHit := range hits {
+				handleHit(rawHit)
+			}
+			continue
+		}
+
+		var response struct {
+			Hits []json.RawMessage `json:"hits"`
+		}
+
+		if err := json.Unmarshal(value, &response); err != nil {
+			return err
+		}
+
+		if response.Hits == nil {
+			handleHit(value)
+			continue
+		}
+
+		for _, rawHit := range response.Hits {
+			handleHit(rawHit)
+		}
+	}
+}
+
+func (hit *algoliaHit) toItem() (item, error) {
+	var algoliaItem item
+
+	id, err := strconv.Atoi(hit.ObjectId)
+	if err != nil {
+		return algoliaItem, fmt.Errorf("Invalid objectID [%s]", hit.ObjectId)
+	}
+
+	// The tags contain the type and some extra tags like author_pg or ask_hn
+	for _, tag := range hit.Tags {
+		switch tag {
+		case "story", "comment", "job", "poll", "pollopt":
+			algoliaItem.ItemType = tag
+		}
+	}
+
+	algoliaItem.Id = id
+	algoliaItem.By = hit.Author
+	algoliaItem.Time = hit.CreatedAtI
+	algoliaItem.Title = hit.Title
+	algoliaItem.Url = hit.Url
+	algoliaItem.Score = hit.Points
+	algoliaItem.Descendants = hit.NumComments
+	algoliaItem.Parent = hit.ParentId
+
+	algoliaItem.Text = hit.StoryText
+	if hit.CommentText != "" {
+		algoliaItem.Text = hit.CommentText
+	}
+
+	return algoliaItem, nil
+}
+
+// Column names of BigQuery exports, including those of the older stories
+// and comments tables, and their Algolia style aliases.
+var bigQueryColumns = map[string]string{
+	"id":           "id",
+	"objectid":     "id",
+	"type":         "type",
+	"by":           "by",
+	"author":       "by",
+	"time":         "time",
+	"created_at_i": "time",
+	"timestamp":    "timestamp",
+	"time_ts":      "timestamp",
+	"title":        "title",
+	"url":          "url",
+	"text":         "text",
+	"comment_text": "text",
+	"story_text":   "text",
+	"parent":       "parent",
+	"parent_id":    "parent",
+	"score":        "score",
+	"points":       "score",
+	"descendants":  "descendants",
+	"num_comments": "descendants",
+	"deleted":      "deleted",
+	"dead":         "dead",
+}
+
+// decodeBigQuery reads a CSV export with a header row. Line numbers are record numbers.
+func decodeBigQuery(reader *bufio.Reader, workers int, handle func(decoded *decodedLine)) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	columns := bigQueryHeaderColumns(header)
+
+	if _, hasKey := columns["id"]; !hasKey {
+		return fmt.Errorf("CSV header has no id column")
+	}
+
+	for recordNumber := 1; ; recordNumber++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		// The reader continues after a broken record, so only the record is bad
+		if parseErr, isParseErr := err.(*csv.ParseError); isParseErr {
+			handle(&decodedLine{lineNumber: recordNumber, err: parseErr})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var line bytes.Buffer
+		csvWriter := csv.NewWriter(&line)
+		csvWriter.Write(record)
+		csvWriter.Flush()
+
+		decoded := decodedLine{lineNumber: recordNumber, line: bytes.TrimRight(line.Bytes(), "\n")}
+		decoded.item, decoded.err = bigQueryItem(record, columns)
+
+		handle(&decoded)
+	}
+}
+
+// bigQueryHeaderColumns maps field names to column indexes. Exports can have
+// several columns for a field, e.g. comment_text and story_text, where only one is filled.
+func bigQueryHeaderColumns(header []string) map[string][]int {
+	columns := make(map[string][]int)
+
+	for index, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+
+		if field, hasKey := bigQueryColumns[name]; hasKey {
+			columns[field] = append(columns[field], index)
+		}
+	}
+
+	return columns
+}
+
+func bigQueryItem(record []string, columns map[string][]int) (item, error) {
+	var exportItem item
+
+	// The first non-empty column of the field
+	field := func(name string) string {
+		for _, index := range columns[name] {
+			if index < len(record) && strings.TrimSpace(record[index]) != "" {
+				return strings.TrimSpace(record[index])
+			}
+		}
+		return ""
+	}
+
+	intField := func(name string) (int64, error) {
+		value := field(name)
+		if value == "" {
+			return 0, nil
+		}
+
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid %s [%s]", name, value)
+		}
+
+		return number, nil
+	}
+
+	id, err := intField("id")
+	if err != nil || id == 0 {
+		return exportItem, fmt.Errorf("Invalid id [%s]", field("id"))
+	}
+
+	parent, err := intField("parent")
+	if err != nil {
+		return exportItem, err
+	}
+
+	score, err := intField("score")
+	if err != nil {
+		return exportItem, err
+	}
+
+	descendants, err := intField("descendants")
+	if err != nil {
+		return exportItem, err
+	}
+
+	itemTime, err := intField("time")
+	if err != nil {
+		return exportItem, err
+	}
+
+	if itemTime == 0 && field("timestamp") != "" {
+		timestamp, err := time.Parse("2006-01-02 15:04:05 MST", field("timestamp"))
+		if err != nil {
+			return exportItem, fmt.Errorf("Invalid timestamp [%s]", field("timestamp"))
+		}
+
+		itemTime = timestamp.Unix()
+	}
+
+	exportItem.Id = int(id)
+	exportItem.ItemType = field("type")
+	exportItem.By = field("by")
+	exportItem.Time = itemTime
+	exportItem.Title = field("title")
+	exportItem.Url = field("url")
+	exportItem.Text = field("text")
+	exportItem.Parent = int(parent)
+	exportItem.Score = int(score)
+	exportItem.Descendants = int(descendants)
+	exportItem.Deleted = strings.EqualFold(field("deleted"), "true")
+	exportItem.Dead = strings.EqualFold(field("dead"), "true")
+
+	// The older stories and comments tables have no type column
+	if exportItem.ItemType == "" {
+		if exportItem.Parent != 0 {
+			exportItem.ItemType = "comment"
+		} else if exportItem.Title != "" {
+			exportItem.ItemType = "story"
+		}
+	}
+
+	return exportItem, nil
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// decodeAll returns the decoded items of input.
+func decodeAll(t *testing.T, decoder itemDecoder, input string) []decodedLine {
+	var lines []decodedLine
+
+	err := decoder(bufio.NewReader(strings.NewReader(input)), 1, func(decoded *decodedLine) {
+		lines = append(lines, *decoded)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+// checkItems compares the decoded items with the expected ones. Only the fields used by the tests are compared.
+func checkItems(t *testing.T, lines []decodedLine, expectedItems []item) {
+	if len(lines) != len(expectedItems) {
+		t.Fatalf("Decoded %d items, expected %d", len(lines), len(expectedItems))
+	}
+
+	for i, decoded := range lines {
+		if decoded.err != nil {
+			t.Errorf("Item %d: %s", i+1, decoded.err)
+			continue
+		}
+
+		if decoded.lineNumber != i+1 {
+			t.Errorf("Item %d has line number %d", i+1, decoded.lineNumber)
+		}
+
+		got := decoded.item
+		want := expectedItems[i]
+
+		if got.Id != want.Id || got.ItemType != want.ItemType || got.By != want.By || got.Time != want.Time ||
+			got.Title != want.Title || got.Url != want.Url || got.Text != want.Text || got.Parent != want.Parent ||
+			got.Score != want.Score || got.Descendants != want.Descendants {
+			t.Errorf("Item %d\n got: %+v\nwant: %+v", i+1, got, want)
+		}
+	}
+}
+
+var algoliaStory = item{Id: 100, ItemType: "story", By: "pg", Time: 1570000000, Title: "Ask HN: Algolia?", Url: "https://example.com", Text: "Story text", Score: 42, Descendants: 2}
+var algoliaComment = item{Id: 101, ItemType: "comment", By: "dang", Time: 1570000100, Text: "A <i>comment</i>", Parent: 100}
+
+const algoliaStoryJson = `{"objectID":"100","_tags":["story","author_pg","ask_hn"],"author":"pg","created_at_i":1570000000,` +
+	`"title":"Ask HN: Algolia?","url":"https://example.com","story_text":"Story text","points":42,"num_comments":2}`
+
+const algoliaCommentJson = `{"objectID":"101","_tags":["comment","author_dang","story_100"],"author":"dang","created_at_i":1570000100,` +
+	`"comment_text":"A <i>comment</i>","parent_id":100,"story_id":100}`
+
+func TestDecodeAlgolia(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"search response", `{"hits":[` + algoliaStoryJson + `,` + algoliaCommentJson + `],"nbHits":2,"page":0}`},
+		{"array", `[` + algoliaStoryJson + `,` + algoliaCommentJson + `]`},
+		{"hit per line", algoliaStoryJson + "\n" + algoliaCommentJson + "\n"},
+		{"response per line", `{"hits":[` + algoliaStoryJson + `]}` + "\n" + `{"hits":[` + algoliaCommentJson + `]}` + "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkItems(t, decodeAll(t, decodeAlgolia, test.input), []item{algoliaStory, algoliaComment})
+		})
+	}
+}
+
+func TestDecodeAlgoliaBadObjectId(t *testing.T) {
+	lines := decodeAll(t, decodeAlgolia, `[{"objectID":"abc","_tags":["comment"]},`+algoliaCommentJson+`]`)
+
+	if len(lines) != 2 || lines[0].err == nil || lines[1].err != nil {
+		t.Fatalf("Expected an error for the first hit only: %+v", lines)
+	}
+
+	if lines[1].lineNumber != 2 {
+		t.Errorf("Second hit has line number %d", lines[1].lineNumber)
+	}
+}
+
+func TestDecodeBigQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		items []item
+	}{
+		{
+			name: "full table",
+			input: "id,type,by,time,title,url,text,parent,score,descendants,deleted,dead\n" +
+				"100,story,pg,1570000000,Hello,https://example.com,,,42,1,,\n" +
+				"101,comment,dang,1570000100,,,\"Quoted, \"\"text\"\"\",100,,,,\n",
+			items: []item{
+				{Id: 100, ItemType: "story", By: "pg", Time: 1570000000, Title: "Hello", Url: "https://example.com", Score: 42, Descendants: 1},
+				{Id: 101, ItemType: "comment", By: "dang", Time: 1570000100, Text: `Quoted, "text"`, Parent: 100},
+			},
+		},
+		{
+			name: "time_ts without type",
+			input: "id,by,time_ts,title,text,parent\n" +
+				"100,pg,2019-10-02 07:06:40 UTC,Hello,,\n" +
+				"101,dang,2019-10-02 07:08:20 UTC,,A comment,100\n",
+			items: []item{
+				{Id: 100, ItemType: "story", By: "pg", Time: 1570000000, Title: "Hello"},
+				{Id: 101, ItemType: "comment", By: "dang", Time: 1570000100, Text: "A comment", Parent: 100},
+			},
+		},
+		{
+			name: "algolia columns with both texts",
+			input: "objectID,title,story_text,comment_text,author,points,parent_id,created_at_i\n" +
+				"100,Hello,Story text,,pg,42,,1570000000\n" +
+				"101,,,A comment,dang,,100,1570000100\n",
+			items: []item{
+				{Id: 100, ItemType: "story", By: "pg", Time: 1570000000, Title: "Hello", Text: "Story text", Score: 42},
+				{Id: 101, ItemType: "comment", By: "dang", Time: 1570000100, Text: "A comment", Parent: 100},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkItems(t, decodeAll(t, decodeBigQuery, test.input), test.items)
+		})
+	}
+}
+
+func TestDecodeBigQueryWithoutId(t *testing.T) {
+	err := decodeBigQuery(bufio.NewReader(strings.NewReader("by,text\npg,hi\n")), 1, func(decoded *decodedLine) {})
+
+	if err == nil {
+		t.Fatal("Expected an error for a header without id")
+	}
+}
+
+func TestDecodeBigQueryBadRecord(t *testing.T) {
+	input := "id,by,text\n" +
+		"1,pg,fine\n" +
+		"2,pg,\"broken \"quote\"\n" +
+		"3,dang,also fine\n"
+
+	var ids []int
+	var badLines []int
+
+	err := decodeBigQuery(bufio.NewReader(strings.NewReader(input)), 1, func(decoded *decodedLine) {
+		if decoded.err != nil {
+			badLines = append(badLines, decoded.lineNumber)
+			return
+		}
+
+		ids = append(ids, decoded.item.Id)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 || len(badLines) != 1 || badLines[0] != 2 {
+		t.Errorf("Items %v, bad lines %v", ids, badLines)
+	}
+}
+
+func TestDetectInputFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", FormatHn},
+		{"hn item", `{"by":"pg","id":1,"type":"story"}` + "\n", FormatHn},
+		{"hn item with whitespace", "\n  {\"id\":1}\n", FormatHn},
+		{"algolia response", `{"hits":[],"nbHits":0}`, FormatAlgolia},
+		{"algolia hit", algoliaCommentJson + "\n", FormatAlgolia},
+		{"algolia array", `[` + algoliaStoryJson + `]`, FormatAlgolia},
+		{"bigquery csv", "id,type,by,time\n1,story,pg,1160418111\n", FormatBigQuery},
+		{"bigquery csv with bom", "\ufeffid,by,time_ts\n", FormatBigQuery},
+		{"hn item with bom", "\ufeff{\"id\":1}\n", FormatHn},
+		{"plain text", "# hacker-bro\n\nSome notes, not items.\n", FormatHn},
+		{"csv without id", "name,value\na,1\n", FormatHn},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := detectInputFormat(bufio.NewReader(strings.NewReader(test.input))); got != test.want {
+				t.Errorf("Detected %s, expected %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,948 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	. "strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// Number of inserted items after which the current transaction is committed.
+// Keeps the journal and the page cache small, even for huge dumps.
+const importBatchSize = 10000
+
+type importCounters struct {
+	readStoryCounter       int
+	newStoryCounter        int
+	deletedStoryCounter    int
+	noCommentsStoryCounter int
+	askHnStoryCounter      int
+	showHnStoryCounter     int
+	emptyStoryCounter      int
+
+	readCommentCounter    int
+	newCommentCounter     int
+	deletedCommentCounter int
+	emptyCommentCounter   int
+
+	readJobCounter int
+	newJobCounter  int
+
+	readPollCounter       int
+	newPollCounter        int
+	readPollOptionCounter int
+	newPollOptionCounter  int
+
+	quarantinedCounter int
+}
+
+type importer struct {
+	conn *sqlite3.Conn
+
+	stmtInsertStories         *sqlite3.Stmt
+	stmtInsertStoriesContent  *sqlite3.Stmt
+	stmtDeleteStoryKids       *sqlite3.Stmt
+	stmtInsertStoryKids       *sqlite3.Stmt
+	stmtInsertImportedStories *sqlite3.Stmt
+	stmtInsertComments        *sqlite3.Stmt
+	stmtInsertCommentsContent *sqlite3.Stmt
+	stmtInsertCommentsHtml    *sqlite3.Stmt
+	stmtInsertJobs            *sqlite3.Stmt
+	stmtInsertPolls           *sqlite3.Stmt
+	stmtInsertPollOptions     *sqlite3.Stmt
+
+	stmtUpdatePollOptionPositions *sqlite3.Stmt
+
+	counters importCounters
+
+	// Links and quotes of comments
+	extras *commentExtras
+
+	// Only set in lenient mode
+	quarantine *quarantine
+
+	// Number of goroutines unmarshalling Json lines
+	workers int
+
+	// Items inserted since the last commit
+	pendingItems int
+
+	// One of the input formats. Auto detects the format of each input.
+	format string
+
+	// Set by -replace. Batches aren't committed, so a file is swapped in a single transaction.
+	atomic bool
+}
+
+// ImportOptions selects the files read by Import.
+type ImportOptions struct {
+	Dir string
+
+	// Glob patterns. Patterns containing a slash are matched against the
+	// path relative to Dir, all others against the file name only.
+	Include []string
+	Exclude []string
+
+	// Files are imported instead of Dir. "-" reads from stdin.
+	Files []string
+
+	// Name stored in the File column. Defaults to the file name.
+	Source string
+
+	// Unparseable lines and unknown item types are written to QuarantinePath
+	// instead of aborting the import.
+	Lenient        bool
+	QuarantinePath string
+
+	// Number of concurrent Json decoders. 1 decodes on the inserting goroutine.
+	Workers int
+
+	// hn for line delimited HN API items, algolia, bigquery or auto
+	Format string
+
+	// Json file with counters per file, timings and orphans. Not written, if empty.
+	ReportPath string
+
+	// Everything imported before from a file is removed and the file is
+	// imported again, all in one transaction. Items missing in the new
+	// version of the file disappear.
+	Replace bool
+}
+
+// importSource is a single input of Import. An empty path means stdin.
+type importSource struct {
+	name string
+	path string
+}
+
+func Import(options ImportOptions) {
+	var sources []importSource
+
+	if len(options.Files) > 0 {
+		sources = findFileSources(options.Files, options.Source)
+	} else {
+		sources = findDirSources(options.Dir, options.Include, options.Exclude)
+	}
+
+	conn := connectDatabase(true)
+	defer conn.Close()
+
+	createImportTempTables(conn)
+
+	progressf("Reading known files from database...\n")
+
+	knownFiles := loadImportedFiles(conn)
+
+	imp := newImporter(conn)
+	defer imp.close()
+
+	imp.workers = options.Workers
+	imp.format = options.Format
+
+	if options.Lenient {
+		imp.quarantine = newQuarantine(options.QuarantinePath)
+		defer imp.quarantine.close()
+	}
+
+	report := newImportReport()
+
+	for _, source := range sources {
+
+		progressf("Loading [%s]...\n", source.name)
+
+		startTime := time.Now()
+		countersBefore := imp.counters
+
+		status, itemCount := imp.importSource(source, knownFiles, options.Replace)
+
+		report.addFile(source.name, status, itemCount, imp.counters, countersBefore, startTime)
+	}
+
+	imp.counters.print()
+
+	if !options.Replace {
+		resolveComments(conn)
+	}
+
+	if options.ReportPath != "" {
+		report.CommentsWithoutStory, report.CommentsWaitingForParent = countOrphanComments(conn)
+		report.write(options.ReportPath, imp.counters)
+	}
+}
+
+// importSource imports a single file or stdin, unless it was imported before.
+// Returns the report status and the number of imported items.
+func (imp *importer) importSource(source importSource, knownFiles *importedFiles, replace bool) (string, int) {
+	if source.path == "" && replace {
+		return reportStatusReplaced, imp.replaceFile(source, fileFingerprint{}, knownFiles)
+	}
+
+	if source.path == "" {
+		if knownFiles.isKnownSource(source.name) {
+			progressf("Skipping [%s]. Already imported.\n", source.name)
+			return reportStatusSkipped, 0
+		}
+
+		return reportStatusImported, imp.importStdin(source.name, knownFiles)
+	}
+
+	fingerprint, err := fingerprintFile(source.path)
+	if err != nil {
+		progressf("Failed to read [%s]: %s\n", source.name, err)
+		os.Exit(1)
+	}
+
+	if replace {
+		if knownFiles.fileByHash[fingerprint.hash] == source.name {
+			progressf("Skipping [%s]. Unchanged.\n", source.name)
+			return reportStatusSkipped, 0
+		}
+
+		return reportStatusReplaced, imp.replaceFile(source, fingerprint, knownFiles)
+	}
+
+	if knownFiles.shouldSkip(source.name, fingerprint) {
+		return reportStatusSkipped, 0
+	}
+
+	return reportStatusImported, imp.importFile(source.path, source.name, fingerprint, knownFiles)
+}
+
+func findDirSources(dir string, include []string, exclude []string) []importSource {
+	dir = TrimSpace(Trim(dir, "\""))
+
+	stat, err := os.Stat(dir)
+
+	if err != nil {
+		progressf("Path error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if !stat.IsDir() {
+		progressf("Path is not a directory\n")
+		os.Exit(1)
+	}
+
+	if dir, err = filepath.Abs(dir); err != nil {
+		progressf("Failed to get absolute path\n")
+		os.Exit(1)
+	}
+
+	for _, patterns := range [][]string{include, exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				progressf("Invalid glob pattern [%s]\n", pattern)
+				os.Exit(1)
+			}
+		}
+	}
+
+	progressf("Importing data from [%s]\n", dir)
+
+	fileNames := findImportFiles(dir, include, exclude)
+
+	progressf("Found %d files\n", len(fileNames))
+
+	var sources []importSource
+
+	for _, fileName := range fileNames {
+		sources = append(sources, importSource{fileName, filepath.Join(dir, filepath.FromSlash(fileName))})
+	}
+
+	return sources
+}
+
+// findFileSources names each file after its base name. Directory imports
+// store the path relative to -dir instead, e.g. 2019/a.json, so the same file
+// has different names. Known content is still skipped by its hash, but
+// -remove and -replace need the stored name.
+func findFileSources(files []string, source string) []importSource {
+	if source != "" && len(files) > 1 {
+		progressf("A source name can only be used with a single input\n")
+		os.Exit(1)
+	}
+
+	var sources []importSource
+
+	for _, filePath := range files {
+		if filePath == "-" {
+			if source == "" {
+				progressf("Please provide a source name for stdin\n")
+				os.Exit(1)
+			}
+
+			sources = append(sources, importSource{source, ""})
+			continue
+		}
+
+		if !fileExists(filePath) {
+			progressf("File not found: [%s]\n", filePath)
+			os.Exit(1)
+		}
+
+		name := source
+		if name == "" {
+			name = filepath.Base(filePath)
+		}
+
+		sources = append(sources, importSource{name, filePath})
+	}
+
+	return sources
+}
+
+// findImportFiles walks dir recursively and returns the slash separated paths
+// of all matching files relative to dir. Hidden files and directories are skipped.
+func findImportFiles(dir string, include []string, exclude []string) []string {
+	var fileNames []string
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if filePath == dir {
+			return nil
+		}
+
+		if HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		fileName := filepath.ToSlash(relativePath)
+
+		if len(include) > 0 && !matchesAnyGlob(include, fileName) {
+			return nil
+		}
+
+		if matchesAnyGlob(exclude, fileName) {
+			return nil
+		}
+
+		fileNames = append(fileNames, fileName)
+		return nil
+	})
+
+	check(err, "Failed to read directory")
+
+	return fileNames
+}
+
+func matchesAnyGlob(patterns []string, fileName string) bool {
+	for _, pattern := range patterns {
+		name := fileName
+		if !Contains(pattern, "/") {
+			name = path.Base(fileName)
+		}
+
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func createImportTempTables(conn *sqlite3.Conn) {
+	// Stories of this run. Their comment trees are resolved again.
+	err := conn.Exec("CREATE TABLE IF NOT EXISTS temp.ImportedStories(StoryId INTEGER PRIMARY KEY)")
+	check(err, "Failed to create ImportedStories table")
+}
+
+func newImporter(conn *sqlite3.Conn) *importer {
+	imp := &importer{conn: conn}
+
+	var err error
+
+	// Items of changed files are imported again. Existing rows are updated, but keep their comment tree.
+	imp.stmtInsertStories, err = conn.Prepare(
+		"INSERT INTO Stories (StoryId, File, CommentCount, By, Time, Score, Url, Descendants, Dead, Text, Kind) Values(?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?) " +
+			"ON CONFLICT (StoryId) DO UPDATE SET File = excluded.File, By = excluded.By, Time = excluded.Time, Score = excluded.Score, " +
+			"Url = excluded.Url, Descendants = excluded.Descendants, Dead = excluded.Dead, Text = excluded.Text, Kind = excluded.Kind")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertStoriesContent, err = conn.Prepare("INSERT OR REPLACE INTO StoriesContent (rowid, Content) Values(?, ?)")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtDeleteStoryKids, err = conn.Prepare("DELETE FROM StoryKids WHERE StoryId = ?")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertStoryKids, err = conn.Prepare("INSERT OR REPLACE INTO StoryKids (CommentId, StoryId, Thread) Values(?, ?, ?)")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertImportedStories, err = conn.Prepare("INSERT OR IGNORE INTO temp.ImportedStories (StoryId) Values(?)")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertComments, err = conn.Prepare(
+		"INSERT INTO Comments (CommentId, StoryId, Parent, Thread, Level, File, By, Time, Dead) Values(?, 0, ?, 0, 0, ?, ?, ?, ?) " +
+			"ON CONFLICT (CommentId) DO UPDATE SET File = excluded.File, By = excluded.By, Time = excluded.Time, Dead = excluded.Dead, " +
+			"StoryId = CASE WHEN Parent = excluded.Parent THEN StoryId ELSE 0 END, " +
+			"Parent = excluded.Parent")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertCommentsContent, err = conn.Prepare("INSERT OR REPLACE INTO CommentsContent (rowid, Content) Values(?, ?)")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertCommentsHtml, err = conn.Prepare("INSERT OR REPLACE INTO CommentsHtml (CommentId, Html) Values(?, ?)")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertJobs, err = conn.Prepare("INSERT OR REPLACE INTO Jobs (JobId, Title, Text, Url, By, Time, Score, Dead, File) Values(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtInsertPolls, err = conn.Prepare("INSERT OR REPLACE INTO Polls (PollId, Title, Text, By, Time, Score, Descendants, Dead, File) Values(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	check(err, "Failed to prepare statement")
+
+	// The position is only known from the Parts of the poll
+	imp.stmtInsertPollOptions, err = conn.Prepare(
+		"INSERT OR REPLACE INTO PollOptions (PollOptionId, PollId, Position, Text, By, Time, Score, Dead, File) " +
+			"Values(?, ?, (SELECT Position FROM PollOptions WHERE PollOptionId = ?), ?, ?, ?, ?, ?, ?)")
+	check(err, "Failed to prepare statement")
+
+	imp.stmtUpdatePollOptionPositions, err = conn.Prepare(
+		"INSERT INTO PollOptions (PollOptionId, PollId, Position) Values(?, ?, ?) " +
+			"ON CONFLICT (PollOptionId) DO UPDATE SET PollId = excluded.PollId, Position = excluded.Position")
+	check(err, "Failed to prepare statement")
+
+	imp.extras = newCommentExtras(conn)
+
+	return imp
+}
+
+func (imp *importer) close() {
+	imp.stmtInsertStories.Close()
+	imp.stmtInsertStoriesContent.Close()
+	imp.stmtDeleteStoryKids.Close()
+	imp.stmtInsertStoryKids.Close()
+	imp.stmtInsertImportedStories.Close()
+	imp.stmtInsertComments.Close()
+	imp.stmtInsertCommentsContent.Close()
+	imp.stmtInsertCommentsHtml.Close()
+	imp.extras.close()
+	imp.stmtInsertJobs.Close()
+	imp.stmtInsertPolls.Close()
+	imp.stmtInsertPollOptions.Close()
+	imp.stmtUpdatePollOptionPositions.Close()
+}
+
+// importFile imports a single, possibly compressed file.
+func (imp *importer) importFile(filePath string, fileName string, fingerprint fileFingerprint, knownFiles *importedFiles) int {
+	openFile, err := openInput(filePath)
+	if err != nil {
+		progressf("Failed to open [%s]: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	defer openFile.Close()
+
+	countersBefore := imp.counters
+	itemCount := imp.importReader(openFile, fileName)
+
+	imp.recordFile(knownFiles, fileName, fingerprint, itemCount, countersBefore)
+
+	return itemCount
+}
+
+// importStdin streams newline delimited items from stdin, like importFile.
+func (imp *importer) importStdin(sourceName string, knownFiles *importedFiles) int {
+	rawInput := newFingerprintReader(os.Stdin)
+
+	input, err := newDecompressReader(rawInput, sourceName)
+	if err != nil {
+		progressf("Failed to read stdin: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer input.Close()
+
+	countersBefore := imp.counters
+	itemCount := imp.importReader(input, sourceName)
+
+	imp.recordFile(knownFiles, sourceName, rawInput.fingerprint(), itemCount, countersBefore)
+
+	return itemCount
+}
+
+// replaceFile removes the old rows of a source and imports it again. Readers
+// see either the old or the new version of the file, never a mix.
+func (imp *importer) replaceFile(source importSource, fingerprint fileFingerprint, knownFiles *importedFiles) int {
+	// Without a journal, a failed replace can't be rolled back
+	if Durability == DurabilityFast {
+		setDurability(imp.conn, DurabilitySafe)
+		defer setDurability(imp.conn, Durability)
+	}
+
+	err := imp.conn.Begin()
+	check(err, "Failed to start transaction")
+
+	storyCount, commentCount, otherCount, isKnown := removeFileRows(imp.conn, source.name)
+
+	if isKnown {
+		progressf("Replacing %d stories, %d comments and %d other items of [%s]\n", storyCount, commentCount, otherCount, source.name)
+	}
+
+	imp.atomic = true
+
+	var itemCount int
+
+	if source.path == "" {
+		itemCount = imp.importStdin(source.name, knownFiles)
+	} else {
+		itemCount = imp.importFile(source.path, source.name, fingerprint, knownFiles)
+	}
+
+	imp.atomic = false
+
+	resolveComments(imp.conn)
+
+	err = imp.conn.Commit()
+	check(err, "Failed to commit transaction")
+
+	return itemCount
+}
+
+func (imp *importer) recordFile(knownFiles *importedFiles, fileName string, fingerprint fileFingerprint, itemCount int, countersBefore importCounters) {
+	storyCount := imp.counters.newStoryCounter - countersBefore.newStoryCounter
+	commentCount := imp.counters.newCommentCounter - countersBefore.newCommentCounter
+
+	knownFiles.record(fileName, fingerprint, itemCount, storyCount, commentCount)
+}
+
+// importReader streams items in the importer's format into the database. Items
+// are inserted while reading and committed every importBatchSize items, so
+// memory usage doesn't depend on the size of the input.
+func (imp *importer) importReader(input io.Reader, fileName string) int {
+	if !imp.atomic {
+		err := imp.conn.Begin()
+		check(err, "Failed to start transaction")
+	}
+
+	progressTime := time.Now()
+	progressIteration := 0
+	itemCounter := 0
+	reader := bufio.NewReader(input)
+
+	format := imp.format
+	if format == "" || format == FormatAuto {
+		format = detectInputFormat(reader)
+		progressf("Reading [%s] as %s\n", fileName, format)
+	}
+
+	decode := itemDecoders[format]
+
+	lastLineNumber := 0
+
+	err := decode(reader, imp.workers, func(decoded *decodedLine) {
+		lastLineNumber = decoded.lineNumber
+
+		if decoded.err != nil {
+			if imp.quarantine == nil {
+				progressf("Failed to parse [%s] on line [%d]: %s\n", fileName, decoded.lineNumber, decoded.err)
+				os.Exit(1)
+			}
+
+			imp.quarantineLine(fileName, decoded.lineNumber, decoded.line, decoded.err)
+			return
+		}
+
+		item := decoded.item
+		item.fileName = fileName
+
+		if err := imp.importItem(item); err != nil {
+			if imp.quarantine == nil {
+				progressf("[%s] Line [%d]: %s\n", fileName, decoded.lineNumber, err)
+				os.Exit(1)
+			}
+
+			imp.quarantineLine(fileName, decoded.lineNumber, decoded.line, err)
+			return
+		}
+
+		itemCounter++
+
+		progressIteration++
+		if progressIteration%1000 == 0 && time.Since(progressTime).Seconds() > 2 {
+
+			progressPerSeconds := float64(progressIteration) / time.Since(progressTime).Seconds()
+
+			progressf("Read %d items from [%s] / %0.1f items per sec\n", itemCounter, fileName, progressPerSeconds)
+
+			progressTime = time.Now()
+			progressIteration = 0
+		}
+	})
+
+	if err != nil && imp.quarantine != nil {
+		// The decoder can't continue, so the rest of the input is skipped
+		imp.quarantineLine(fileName, lastLineNumber+1, nil, err)
+		err = nil
+	}
+	check(err, "Failed to read line")
+
+	if !imp.atomic {
+		err = imp.conn.Commit()
+		check(err, "Failed to commit transaction")
+	}
+
+	imp.pendingItems = 0
+
+	progressf("Read [%d] items from [%s]\n", itemCounter, fileName)
+
+	return itemCounter
+}
+
+func (imp *importer) quarantineLine(fileName string, lineNumber int, line []byte, reason error) {
+	imp.quarantine.add(fileName, lineNumber, line, reason)
+	imp.counters.quarantinedCounter++
+}
+
+// importItem filters a single item and inserts it, if it's valid.
+func (imp *importer) importItem(currentItem item) error {
+	counters := &imp.counters
+
+	if currentItem.ItemType == "story" {
+		counters.readStoryCounter++
+
+		if currentItem.Deleted {
+			counters.deletedStoryCounter++
+			return nil
+		}
+
+		if len(currentItem.Title) == 0 {
+			counters.emptyStoryCounter++
+			return nil
+		}
+
+		// Algolia and BigQuery exports only have the number of comments
+		if len(currentItem.Kids) == 0 && currentItem.Descendants == 0 {
+			counters.noCommentsStoryCounter++
+			return nil
+		}
+
+		kind := storyKindOf(currentItem.Title)
+
+		if kind == storyKindAsk {
+			counters.askHnStoryCounter++
+		} else if kind == storyKindShow {
+			counters.showHnStoryCounter++
+		}
+
+		counters.newStoryCounter++
+		imp.insertStory(currentItem, kind)
+
+	} else if currentItem.ItemType == "comment" {
+
+		counters.readCommentCounter++
+
+		if currentItem.Deleted {
+			counters.deletedCommentCounter++
+			return nil
+		}
+
+		if len(currentItem.Text) == 0 {
+			counters.emptyCommentCounter++
+			return nil
+		}
+
+		counters.newCommentCounter++
+		imp.insertComment(currentItem)
+
+	} else if currentItem.ItemType == "job" {
+
+		counters.readJobCounter++
+
+		if currentItem.Deleted {
+			return nil
+		}
+
+		counters.newJobCounter++
+		imp.insertJob(currentItem)
+
+	} else if currentItem.ItemType == "poll" {
+
+		counters.readPollCounter++
+
+		if currentItem.Deleted {
+			return nil
+		}
+
+		counters.newPollCounter++
+		imp.insertPoll(currentItem)
+
+		// The discussion of a poll is ranked like any other story
+		if len(currentItem.Title) > 0 && len(currentItem.Kids) > 0 {
+			imp.insertStory(currentItem, storyKindPoll)
+		}
+
+	} else if currentItem.ItemType == "pollopt" {
+
+		// A single answer of a poll
+		counters.readPollOptionCounter++
+
+		if currentItem.Deleted {
+			return nil
+		}
+
+		counters.newPollOptionCounter++
+		imp.insertPollOption(currentItem)
+
+	} else {
+
+		return fmt.Errorf("Unknown item type [%s]", currentItem.ItemType)
+	}
+
+	return nil
+}
+
+func (imp *importer) insertStory(storyItem item, kind string) {
+
+	// TODO: Check if len(item.items) == len(item.kids)
+
+	// Hint: Text is set for Ask HN and other text posts
+	err := imp.stmtInsertStories.Exec(storyItem.Id, storyItem.fileName,
+		storyItem.By, storyItem.Time, storyItem.Score, storyItem.Url, storyItem.Descendants, storyItem.Dead, storyItem.Text, kind)
+	check(err, "Failed to insert story")
+
+	err = imp.stmtInsertStoriesContent.Exec(storyItem.Id, storyItem.Title)
+	check(err, "Failed to insert story content")
+
+	err = imp.stmtDeleteStoryKids.Exec(storyItem.Id)
+	check(err, "Failed to delete story kids")
+
+	for threadIdIndex, threadId := range storyItem.Kids {
+		err = imp.stmtInsertStoryKids.Exec(threadId, storyItem.Id, threadIdIndex+1)
+		check(err, "Failed to insert story kids")
+	}
+
+	err = imp.stmtInsertImportedStories.Exec(storyItem.Id)
+	check(err, "Failed to insert imported story")
+
+	imp.itemInserted()
+}
+
+func (imp *importer) insertJob(jobItem item) {
+	err := imp.stmtInsertJobs.Exec(jobItem.Id, jobItem.Title, jobItem.Text, jobItem.Url,
+		jobItem.By, jobItem.Time, jobItem.Score, jobItem.Dead, jobItem.fileName)
+	check(err, "Failed to insert job")
+
+	imp.itemInserted()
+}
+
+func (imp *importer) insertPoll(pollItem item) {
+	err := imp.stmtInsertPolls.Exec(pollItem.Id, pollItem.Title, pollItem.Text,
+		pollItem.By, pollItem.Time, pollItem.Score, pollItem.Descendants, pollItem.Dead, pollItem.fileName)
+	check(err, "Failed to insert poll")
+
+	// Options may be imported before or after their poll
+	for position, pollOptionId := range pollItem.Parts {
+		err = imp.stmtUpdatePollOptionPositions.Exec(pollOptionId, pollItem.Id, position+1)
+		check(err, "Failed to update poll option")
+	}
+
+	imp.itemInserted()
+}
+
+func (imp *importer) insertPollOption(pollOptionItem item) {
+	err := imp.stmtInsertPollOptions.Exec(pollOptionItem.Id, pollOptionItem.Poll, pollOptionItem.Id, pollOptionItem.Text,
+		pollOptionItem.By, pollOptionItem.Time, pollOptionItem.Score, pollOptionItem.Dead, pollOptionItem.fileName)
+	check(err, "Failed to insert poll option")
+
+	imp.itemInserted()
+}
+
+func (imp *importer) insertComment(commentItem item) {
+
+	comment := parseComment(commentItem.Text)
+
+	err := imp.stmtInsertComments.Exec(commentItem.Id, commentItem.Parent, commentItem.fileName,
+		commentItem.By, commentItem.Time, commentItem.Dead)
+	check(err, "Failed to insert comment")
+
+	err = imp.stmtInsertCommentsContent.Exec(commentItem.Id, comment.text)
+	check(err, "Failed to insert comment content")
+
+	imp.extras.store(commentItem.Id, comment)
+
+	err = imp.stmtInsertCommentsHtml.Exec(commentItem.Id, commentItem.Text)
+	check(err, "Failed to insert comment html")
+
+	imp.itemInserted()
+}
+
+// itemInserted commits the running transaction after every importBatchSize items.
+func (imp *importer) itemInserted() {
+	imp.pendingItems++
+
+	if imp.pendingItems < importBatchSize || imp.atomic {
+		return
+	}
+
+	err := imp.conn.Commit()
+	check(err, "Failed to commit transaction")
+
+	err = imp.conn.Begin()
+	check(err, "Failed to start transaction")
+
+	imp.pendingItems = 0
+}
+
+func (counters *importCounters) print() {
+	resultf("\n")
+	resultf("Read stories: %d\n", counters.readStoryCounter)
+	resultf("New valid stories: %d\n", counters.newStoryCounter)
+	resultf("Deleted stories: %d\n", counters.deletedStoryCounter)
+	resultf("Empty stories: %d\n", counters.emptyStoryCounter)
+	resultf("Ask HN stories: %d\n", counters.askHnStoryCounter)
+	resultf("Show HN stories: %d\n", counters.showHnStoryCounter)
+	resultf("Stories with no comments: %d\n", counters.noCommentsStoryCounter)
+
+	resultf("\n")
+	resultf("Read jobs: %d\n", counters.readJobCounter)
+	resultf("New jobs: %d\n", counters.newJobCounter)
+
+	resultf("\n")
+	resultf("Read polls: %d\n", counters.readPollCounter)
+	resultf("New polls: %d\n", counters.newPollCounter)
+	resultf("Read poll options: %d\n", counters.readPollOptionCounter)
+	resultf("New poll options: %d\n", counters.newPollOptionCounter)
+
+	resultf("\n")
+	resultf("Read comments: %d\n", counters.readCommentCounter)
+	resultf("New valid comments: %d\n", counters.newCommentCounter)
+	resultf("Deleted comments: %d\n", counters.deletedCommentCounter)
+	resultf("Empty comments: %d\n", counters.emptyCommentCounter)
+
+	resultf("\n")
+	resultf("Quarantined lines: %d\n", counters.quarantinedCounter)
+}
+
+// resolveComments links all comments without a story to their story, by
+// walking up the parent chain one level per pass. Comments of stories imported
+// in this run are resolved again, so Thread and Level match the current Kids.
+// Runs entirely in SQL, so the comment tree doesn't have to be loaded into memory.
+// Joins the running transaction, if there is one.
+func resolveComments(conn *sqlite3.Conn) {
+	ownTransaction := conn.AutoCommit()
+
+	progressf("Setting comment parents...\n")
+
+	err := conn.Exec("UPDATE Comments SET StoryId = 0 WHERE StoryId IN (SELECT StoryId FROM temp.ImportedStories)")
+	check(err, "Failed to reset comments of imported stories")
+
+	err = conn.Exec("DELETE FROM temp.ImportedStories")
+	check(err, "Failed to truncate ImportedStories table")
+
+	err = conn.Exec("DROP TABLE IF EXISTS temp.UnresolvedComments")
+	check(err, "Failed to drop UnresolvedComments table")
+
+	err = conn.Exec("CREATE TABLE temp.UnresolvedComments AS SELECT CommentId FROM Comments WHERE StoryId = 0")
+	check(err, "Failed to create UnresolvedComments table")
+
+	if ownTransaction {
+		err = conn.Begin()
+		check(err, "Failed to start transaction")
+	}
+
+	// Level 1: The parent is the story itself
+	err = conn.Exec(
+		"UPDATE Comments SET StoryId = Parent, Level = 1, " +
+			"Thread = IFNULL((SELECT Thread FROM StoryKids WHERE StoryKids.CommentId = Comments.CommentId), 0) " +
+			"WHERE StoryId = 0 AND EXISTS (SELECT 1 FROM Stories WHERE Stories.StoryId = Comments.Parent)")
+	check(err, "Failed to resolve top level comments")
+
+	// Algolia and BigQuery exports have no kids, so their top level comments
+	// are numbered by time after the known kids of the story
+	err = conn.Exec(
+		"UPDATE Comments SET Thread = " +
+			"(SELECT COUNT(*) FROM StoryKids WHERE StoryKids.StoryId = Comments.StoryId) + " +
+			"(SELECT COUNT(*) FROM Comments AS Siblings WHERE Siblings.StoryId = Comments.StoryId AND Siblings.Level = 1 " +
+			"AND NOT EXISTS (SELECT 1 FROM StoryKids WHERE StoryKids.CommentId = Siblings.CommentId) " +
+			"AND (IFNULL(Siblings.Time, 0), Siblings.CommentId) < (IFNULL(Comments.Time, 0), Comments.CommentId)) + 1 " +
+			"WHERE Level = 1 AND Thread = 0 AND CommentId IN (SELECT CommentId FROM temp.UnresolvedComments)")
+	check(err, "Failed to number top level comments")
+
+	// Level 2+: Inherit story and thread from the parent comment
+	for pass := 1; ; pass++ {
+		err = conn.Exec(
+			"UPDATE Comments SET (StoryId, Level, Thread) = " +
+				"(SELECT ParentComments.StoryId, ParentComments.Level + 1, ParentComments.Thread FROM Comments AS ParentComments WHERE ParentComments.CommentId = Comments.Parent) " +
+				"WHERE StoryId = 0 AND EXISTS (SELECT 1 FROM Comments AS ParentComments WHERE ParentComments.CommentId = Comments.Parent AND ParentComments.StoryId > 0)")
+		check(err, "Failed to resolve comments")
+
+		changes := conn.Changes()
+		if changes == 0 {
+			break
+		}
+
+		progressf("Pass %d: Resolved %d comments\n", pass, changes)
+	}
+
+	progressf("Setting new comment count of stories...\n")
+
+	err = conn.Exec(
+		"UPDATE Stories SET CommentCount = (SELECT COUNT(*) FROM Comments WHERE Comments.StoryId = Stories.StoryId) " +
+			"WHERE StoryId IN (SELECT Comments.StoryId FROM Comments INNER JOIN temp.UnresolvedComments ON (Comments.CommentId = UnresolvedComments.CommentId) WHERE Comments.StoryId > 0)")
+	check(err, "Failed to update comment counts")
+
+	if ownTransaction {
+		err = conn.Commit()
+		check(err, "Failed to commit transaction")
+	}
+
+	lostCommentCount, missingParentCount := countOrphanComments(conn)
+
+	progressf("Comments without story: %d\n", lostCommentCount)
+	progressf("Comments waiting for a missing parent: %d\n", missingParentCount)
+
+	err = conn.Exec("DROP TABLE temp.UnresolvedComments")
+	check(err, "Failed to drop UnresolvedComments table")
+}
+
+// countOrphanComments counts the comments without story and those of them,
+// whose parent wasn't imported at all. The rest are replies to those.
+func countOrphanComments(conn *sqlite3.Conn) (int, int) {
+	lostCommentCount := queryScalar(conn, "SELECT COUNT(*) FROM Comments WHERE StoryId = 0")
+
+	missingParentCount := queryScalar(conn,
+		"SELECT COUNT(*) FROM Comments WHERE StoryId = 0 "+
+			"AND NOT EXISTS (SELECT 1 FROM Comments AS ParentComments WHERE ParentComments.CommentId = Comments.Parent) "+
+			"AND NOT EXISTS (SELECT 1 FROM Stories WHERE Stories.StoryId = Comments.Parent)")
+
+	return lostCommentCount, missingParentCount
+}
+
+// readLine reads a complete line, regardless of its length.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var lineBuffer bytes.Buffer
+
+	for {
+		tempBuffer, isPrefix, err := reader.ReadLine()
+		lineBuffer.Write(tempBuffer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !isPrefix {
+			return lineBuffer.Bytes(), nil
+		}
+	}
+}
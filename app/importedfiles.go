@@ -0,0 +1,199 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// fileFingerprint identifies the content of an imported file.
+type fileFingerprint struct {
+	hash    string
+	size    int64
+	modTime int64
+}
+
+// importedFiles tracks which content was already imported, independent of the file name.
+type importedFiles struct {
+	conn *sqlite3.Conn
+
+	// Hash -> File, the first one with this content
+	fileByHash map[string]string
+
+	// File -> Hash
+	hashByFile map[string]string
+
+	// Files imported before ImportedFiles existed. Only known by name.
+	legacyFiles map[string]struct{}
+}
+
+func loadImportedFiles(conn *sqlite3.Conn) *importedFiles {
+	files := &importedFiles{
+		conn:        conn,
+		fileByHash:  make(map[string]string),
+		hashByFile:  make(map[string]string),
+		legacyFiles: make(map[string]struct{}),
+	}
+
+	var hash string
+	var fileName string
+
+	{
+		stmt, err := conn.Prepare("SELECT Hash, File FROM ImportedFiles ORDER BY ImportedAt")
+		check(err, "Failed to create query statememt")
+
+		defer stmt.Close()
+
+		for {
+			hasRows, err := stmt.Step()
+			check(err, "Failed to step")
+
+			if !hasRows {
+				break
+			}
+
+			err = stmt.Scan(&hash, &fileName)
+			check(err, "Failed to scan")
+
+			if _, hasKey := files.fileByHash[hash]; !hasKey {
+				files.fileByHash[hash] = fileName
+			}
+			files.hashByFile[fileName] = hash
+		}
+	}
+
+	{
+		stmt, err := conn.Prepare(
+			"SELECT File FROM Stories UNION SELECT File FROM Comments " +
+				"EXCEPT SELECT File FROM ImportedFiles")
+		check(err, "Failed to create query statememt")
+
+		defer stmt.Close()
+
+		for {
+			hasRows, err := stmt.Step()
+			check(err, "Failed to step")
+
+			if !hasRows {
+				break
+			}
+
+			err = stmt.Scan(&fileName)
+			check(err, "Failed to scan")
+
+			files.legacyFiles[fileName] = struct{}{}
+		}
+	}
+
+	return files
+}
+
+// shouldSkip decides whether a file has to be imported. Files imported under
+// another name are skipped, changed files are imported again.
+func (files *importedFiles) shouldSkip(fileName string, fingerprint fileFingerprint) bool {
+	if knownFile, hasKey := files.fileByHash[fingerprint.hash]; hasKey {
+		if knownFile == fileName {
+			progressf("Skipping [%s]. Already imported.\n", fileName)
+		} else {
+			progressf("Skipping [%s]. Already imported as [%s].\n", fileName, knownFile)
+		}
+		return true
+	}
+
+	if _, hasKey := files.legacyFiles[fileName]; hasKey {
+		progressf("Skipping [%s]. Already imported. Recording content hash.\n", fileName)
+
+		storyCount := queryScalar(files.conn, "SELECT COUNT(*) FROM Stories WHERE File = ?", fileName)
+		commentCount := queryScalar(files.conn, "SELECT COUNT(*) FROM Comments WHERE File = ?", fileName)
+
+		files.record(fileName, fingerprint, 0, storyCount, commentCount)
+		return true
+	}
+
+	if _, hasKey := files.hashByFile[fileName]; hasKey {
+		progressf("[%s] changed since the last import. Importing again.\n", fileName)
+	}
+
+	return false
+}
+
+// isKnownSource is used for stdin, where the content hash isn't known until everything is read.
+func (files *importedFiles) isKnownSource(sourceName string) bool {
+	_, isLegacy := files.legacyFiles[sourceName]
+	_, isKnown := files.hashByFile[sourceName]
+
+	return isLegacy || isKnown
+}
+
+// record stores the fingerprint of an imported file. Older fingerprints of the
+// same file are replaced, other files with the same content are kept.
+func (files *importedFiles) record(fileName string, fingerprint fileFingerprint, itemCount int, storyCount int, commentCount int) {
+	err := files.conn.Exec(
+		"INSERT OR REPLACE INTO ImportedFiles (Hash, File, Size, ModTime, ItemCount, StoryCount, CommentCount, ImportedAt) Values(?, ?, ?, ?, ?, ?, ?, ?)",
+		fingerprint.hash, fileName, fingerprint.size, fingerprint.modTime, itemCount, storyCount, commentCount, time.Now().Unix())
+	check(err, "Failed to record imported file")
+
+	delete(files.legacyFiles, fileName)
+
+	if _, hasKey := files.fileByHash[fingerprint.hash]; !hasKey {
+		files.fileByHash[fingerprint.hash] = fileName
+	}
+	files.hashByFile[fileName] = fingerprint.hash
+}
+
+func fingerprintFile(filePath string) (fileFingerprint, error) {
+	var fingerprint fileFingerprint
+
+	openFile, err := os.Open(filePath)
+	if err != nil {
+		return fingerprint, err
+	}
+
+	defer openFile.Close()
+
+	stat, err := openFile.Stat()
+	if err != nil {
+		return fingerprint, err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, openFile); err != nil {
+		return fingerprint, err
+	}
+
+	fingerprint.hash = hex.EncodeToString(hasher.Sum(nil))
+	fingerprint.size = stat.Size()
+	fingerprint.modTime = stat.ModTime().Unix()
+
+	return fingerprint, nil
+}
+
+// fingerprintReader hashes everything read through it. Used for stdin.
+type fingerprintReader struct {
+	reader io.Reader
+	hasher hash.Hash
+	size   int64
+}
+
+func newFingerprintReader(reader io.Reader) *fingerprintReader {
+	return &fingerprintReader{reader: reader, hasher: sha256.New()}
+}
+
+func (reader *fingerprintReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.hasher.Write(p[:n])
+	reader.size += int64(n)
+	return n, err
+}
+
+func (reader *fingerprintReader) fingerprint() fileFingerprint {
+	return fileFingerprint{
+		hash: hex.EncodeToString(reader.hasher.Sum(nil)),
+		size: reader.size,
+	}
+}
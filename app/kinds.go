@@ -0,0 +1,84 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Values of Stories.Kind
+const (
+	storyKindStory = "story"
+	storyKindAsk   = "ask"
+	storyKindShow  = "show"
+	storyKindPoll  = "poll"
+)
+
+var storyKinds = []string{storyKindStory, storyKindAsk, storyKindShow, storyKindPoll}
+
+func storyKindOf(title string) string {
+	if strings.HasPrefix(title, "Ask HN:") {
+		return storyKindAsk
+	}
+
+	if strings.HasPrefix(title, "Show HN:") {
+		return storyKindShow
+	}
+
+	return storyKindStory
+}
+
+// ParseKinds parses a comma separated list of story kinds. An empty list selects all kinds.
+func ParseKinds(kinds string) []string {
+	parsedKinds, err := parseKinds(kinds)
+	if err != nil {
+		progressf("%s\n", err)
+		os.Exit(1)
+	}
+
+	return parsedKinds
+}
+
+func parseKinds(kinds string) ([]string, error) {
+	var parsedKinds []string
+
+	for _, kind := range strings.Split(kinds, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+
+		if kind == "" {
+			continue
+		}
+
+		isKnown := false
+		for _, knownKind := range storyKinds {
+			if kind == knownKind {
+				isKnown = true
+				break
+			}
+		}
+
+		if !isKnown {
+			return nil, fmt.Errorf("Unknown kind [%s]. Use one of: %s", kind, strings.Join(storyKinds, ", "))
+		}
+
+		parsedKinds = append(parsedKinds, kind)
+	}
+
+	return parsedKinds, nil
+}
+
+// kindCondition returns an SQL condition on Stories.Kind and its arguments.
+// Without kinds, the condition is always true. Comments, whose story wasn't
+// imported yet, have no kind and only match without kinds.
+func kindCondition(kinds []string) (string, []interface{}) {
+	if len(kinds) == 0 {
+		return "1", nil
+	}
+
+	var args []interface{}
+	for _, kind := range kinds {
+		args = append(args, kind)
+	}
+
+	return "Stories.Kind IN (?" + strings.Repeat(", ?", len(kinds)-1) + ")", args
+}
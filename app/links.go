@@ -0,0 +1,75 @@
+package app
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// commentExtras stores the links and quotes removed from a comment's text.
+type commentExtras struct {
+	stmtDeleteLinks  *sqlite3.Stmt
+	stmtInsertLinks  *sqlite3.Stmt
+	stmtDeleteQuotes *sqlite3.Stmt
+	stmtInsertQuotes *sqlite3.Stmt
+}
+
+func newCommentExtras(conn *sqlite3.Conn) *commentExtras {
+	extras := &commentExtras{}
+
+	var err error
+
+	extras.stmtDeleteLinks, err = conn.Prepare("DELETE FROM Links WHERE CommentId = ?")
+	check(err, "Failed to prepare statement")
+
+	extras.stmtInsertLinks, err = conn.Prepare("INSERT INTO Links (CommentId, Url, Domain) Values(?, ?, ?)")
+	check(err, "Failed to prepare statement")
+
+	extras.stmtDeleteQuotes, err = conn.Prepare("DELETE FROM Quotes WHERE CommentId = ?")
+	check(err, "Failed to prepare statement")
+
+	extras.stmtInsertQuotes, err = conn.Prepare("INSERT INTO Quotes (CommentId, Text) Values(?, ?)")
+	check(err, "Failed to prepare statement")
+
+	return extras
+}
+
+func (extras *commentExtras) close() {
+	extras.stmtDeleteLinks.Close()
+	extras.stmtInsertLinks.Close()
+	extras.stmtDeleteQuotes.Close()
+	extras.stmtInsertQuotes.Close()
+}
+
+// store replaces the links and quotes of a comment.
+func (extras *commentExtras) store(commentId int, comment parsedComment) {
+	err := extras.stmtDeleteLinks.Exec(commentId)
+	check(err, "Failed to delete links")
+
+	err = extras.stmtDeleteQuotes.Exec(commentId)
+	check(err, "Failed to delete quotes")
+
+	for _, link := range comment.links {
+		link = strings.TrimRight(link, ".,;:!?)\"'")
+
+		err = extras.stmtInsertLinks.Exec(commentId, link, linkDomain(link))
+		check(err, "Failed to insert link")
+	}
+
+	for _, quote := range comment.quotes {
+		err = extras.stmtInsertQuotes.Exec(commentId, quote)
+		check(err, "Failed to insert quote")
+	}
+}
+
+// linkDomain returns the lower case host of a link without "www.".
+// Relative or broken links have no domain.
+func linkDomain(link string) string {
+	parsedUrl, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(parsedUrl.Hostname()), "www.")
+}
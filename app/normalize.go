@@ -0,0 +1,189 @@
+package app
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Code blocks are fenced like in Markdown, so they stay searchable but can be
+// skipped when generating the word map.
+const codeFence = "```"
+
+// Marks a quoted paragraph, where quotes are shown
+const quoteMarker = "> "
+
+var (
+	reNormalizeUrls       = regexp.MustCompile(`\bhttps?://\S+`)
+	reNormalizeQuoteStart = regexp.MustCompile(`^(>\s*)+`)
+	reNormalizeWhitespace = regexp.MustCompile(`\s+`)
+	reNormalizeCodeBlocks = regexp.MustCompile("(?s)" + codeFence + "\n.*?\n" + codeFence)
+)
+
+// parsedComment is the normalized text of a comment and what was removed from it.
+type parsedComment struct {
+	text string
+
+	// Link targets and bare urls
+	links []string
+
+	// Paragraphs starting with ">", without the quote marker
+	quotes []string
+}
+
+// textNormalizer collects the paragraphs of a comment while walking its HTML tokens.
+type textNormalizer struct {
+	paragraphs []string
+	current    strings.Builder
+
+	links  []string
+	quotes []string
+
+	inCode   bool
+	linkHref string
+}
+
+// parseComment converts the HTML of a HN comment to plain text. Entities are
+// decoded, links and quote markers removed and returned separately. Paragraphs
+// are separated by an empty line and <pre><code> blocks are kept verbatim
+// between code fences.
+func parseComment(rawHtml string) parsedComment {
+	normalizer := &textNormalizer{}
+	tokenizer := html.NewTokenizer(strings.NewReader(rawHtml))
+
+	for {
+		tokenType := tokenizer.Next()
+
+		if tokenType == html.ErrorToken {
+			// io.EOF or broken HTML. Either way, keep what we have.
+			break
+		}
+
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.TextToken:
+			normalizer.text(token.Data)
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			normalizer.startTag(token)
+
+		case html.EndTagToken:
+			normalizer.endTag(token)
+		}
+	}
+
+	normalizer.endParagraph()
+
+	return parsedComment{
+		text:   strings.Join(normalizer.paragraphs, "\n\n"),
+		links:  normalizer.links,
+		quotes: normalizer.quotes,
+	}
+}
+
+// removeCodeBlocks drops the fenced code blocks of normalized text.
+func removeCodeBlocks(text string) string {
+	return reNormalizeCodeBlocks.ReplaceAllString(text, " ")
+}
+
+func (normalizer *textNormalizer) text(data string) {
+	if normalizer.inCode {
+		normalizer.current.WriteString(data)
+		return
+	}
+
+	// HN puts the url itself into the link text, often shortened
+	if normalizer.linkHref != "" && isLinkText(data, normalizer.linkHref) {
+		normalizer.current.WriteString(" ")
+		return
+	}
+
+	normalizer.current.WriteString(data)
+}
+
+func (normalizer *textNormalizer) startTag(token html.Token) {
+	switch token.Data {
+	case "p":
+		normalizer.endParagraph()
+
+	case "br":
+		normalizer.current.WriteString("\n")
+
+	case "pre":
+		normalizer.endParagraph()
+		normalizer.inCode = true
+
+	case "a":
+		normalizer.linkHref = ""
+		for _, attribute := range token.Attr {
+			if attribute.Key == "href" {
+				normalizer.linkHref = attribute.Val
+			}
+		}
+
+		if normalizer.linkHref != "" && !normalizer.inCode {
+			normalizer.links = append(normalizer.links, normalizer.linkHref)
+		}
+	}
+}
+
+func (normalizer *textNormalizer) endTag(token html.Token) {
+	switch token.Data {
+	case "p":
+		normalizer.endParagraph()
+
+	case "pre":
+		normalizer.endParagraph()
+		normalizer.inCode = false
+
+	case "a":
+		normalizer.linkHref = ""
+	}
+}
+
+func (normalizer *textNormalizer) endParagraph() {
+	text := normalizer.current.String()
+	normalizer.current.Reset()
+
+	if normalizer.inCode {
+		text = strings.Trim(text, "\n")
+
+		if strings.TrimSpace(text) != "" {
+			normalizer.paragraphs = append(normalizer.paragraphs, codeFence+"\n"+text+"\n"+codeFence)
+		}
+		return
+	}
+
+	// Urls, which weren't wrapped in a link
+	normalizer.links = append(normalizer.links, reNormalizeUrls.FindAllString(text, -1)...)
+
+	text = reNormalizeUrls.ReplaceAllString(text, " ")
+	text = strings.TrimSpace(text)
+
+	isQuote := reNormalizeQuoteStart.MatchString(text)
+
+	text = reNormalizeQuoteStart.ReplaceAllString(text, "")
+	text = reNormalizeWhitespace.ReplaceAllString(text, " ")
+	text = strings.TrimSpace(text)
+
+	if text != "" {
+		normalizer.paragraphs = append(normalizer.paragraphs, text)
+
+		if isQuote {
+			normalizer.quotes = append(normalizer.quotes, text)
+		}
+	}
+}
+
+func isLinkText(text string, href string) bool {
+	text = strings.TrimSpace(text)
+
+	if strings.HasPrefix(text, "http://") || strings.HasPrefix(text, "https://") {
+		return true
+	}
+
+	// Shortened link texts end with "..."
+	return text != "" && strings.HasPrefix(href, strings.TrimSuffix(text, "..."))
+}
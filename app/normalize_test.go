@@ -0,0 +1,144 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseComment(t *testing.T) {
+	tests := []struct {
+		name   string
+		html   string
+		text   string
+		links  []string
+		quotes []string
+	}{
+		{
+			name: "named entities",
+			html: `He said &quot;no&quot; &amp; left &lt;now&gt;`,
+			text: `He said "no" & left <now>`,
+		},
+		{
+			name: "numeric entities",
+			html: `It&#x27;s a/b&#x2F;c and &#39;d&#39;`,
+			text: `It's a/b/c and 'd'`,
+		},
+		{
+			name: "brackets are kept",
+			html: `The article [1] says [...] something`,
+			text: `The article [1] says [...] something`,
+		},
+		{
+			name: "paragraphs",
+			html: `First sentence. Second one.<p>New paragraph.<p>Third`,
+			text: "First sentence. Second one.\n\nNew paragraph.\n\nThird",
+		},
+		{
+			name: "whitespace",
+			html: "  Lots   of\n\tspace  ",
+			text: "Lots of space",
+		},
+		{
+			name: "code block",
+			html: "Try this:<p><pre><code>  if x &lt; 1 {\n    return\n  }\n</code></pre>Done",
+			text: "Try this:\n\n```\n  if x < 1 {\n    return\n  }\n```\n\nDone",
+		},
+		{
+			name:  "link text equal to href",
+			html:  `See <a href="https://example.com/a" rel="nofollow">https://example.com/a</a> for more`,
+			text:  "See for more",
+			links: []string{"https://example.com/a"},
+		},
+		{
+			name:  "shortened link text",
+			html:  `See <a href="https://example.com/very/long/path">https://example.com/very/lo...</a>`,
+			text:  "See",
+			links: []string{"https://example.com/very/long/path"},
+		},
+		{
+			name:  "link with own text",
+			html:  `Read <a href="https://example.com/b">this post</a> first`,
+			text:  "Read this post first",
+			links: []string{"https://example.com/b"},
+		},
+		{
+			name:  "bare url",
+			html:  `Source: https://example.com/c?x=1 and more`,
+			text:  "Source: and more",
+			links: []string{"https://example.com/c?x=1"},
+		},
+		{
+			name:   "quote",
+			html:   `&gt; The sky is blue<p>No it isn't`,
+			text:   "The sky is blue\n\nNo it isn't",
+			quotes: []string{"The sky is blue"},
+		},
+		{
+			name:   "nested quote",
+			html:   `&gt;&gt; first<p>&gt; second<p>answer`,
+			text:   "first\n\nsecond\n\nanswer",
+			quotes: []string{"first", "second"},
+		},
+		{
+			name: "empty",
+			html: ``,
+			text: ``,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed := parseComment(test.html)
+
+			if parsed.text != test.text {
+				t.Errorf("text\n got: %q\nwant: %q", parsed.text, test.text)
+			}
+
+			if !reflect.DeepEqual(parsed.links, test.links) {
+				t.Errorf("links\n got: %q\nwant: %q", parsed.links, test.links)
+			}
+
+			if !reflect.DeepEqual(parsed.quotes, test.quotes) {
+				t.Errorf("quotes\n got: %q\nwant: %q", parsed.quotes, test.quotes)
+			}
+		})
+	}
+}
+
+func TestRemoveCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "no code",
+			text: "Just text.",
+			want: "Just text.",
+		},
+		{
+			name: "code between paragraphs",
+			text: "Before\n\n```\nx := 1\n```\n\nAfter",
+			want: "Before\n\n \n\nAfter",
+		},
+		{
+			name: "two blocks",
+			text: "```\na\n```\n\nmiddle\n\n```\nb\n```",
+			want: " \n\nmiddle\n\n ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := removeCodeBlocks(test.text); got != test.want {
+				t.Errorf("\n got: %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+
+	// The way from HTML to training text
+	text := removeCodeBlocks(parseComment("Use<pre><code>rm -rf /\n</code></pre>with care").text)
+	if text != "Use\n\n \n\nwith care" {
+		t.Errorf("Code of HTML comment not removed: %q", text)
+	}
+}
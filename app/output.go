@@ -0,0 +1,171 @@
+package app
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Output formats of command results
+const (
+	OutputText   = "text"
+	OutputJson   = "json"
+	OutputCsv    = "csv"
+	OutputNdjson = "ndjson"
+)
+
+var outputFormats = []string{OutputText, OutputJson, OutputCsv, OutputNdjson}
+
+// Output is the format of command results. Set it with SetOutput.
+var Output = OutputText
+
+// Where results are written. Progress, errors and other messages go to
+// progressFile, so stdout can be piped into scripts.
+var resultFile io.Writer = os.Stdout
+var progressFile io.Writer = os.Stderr
+
+// SetOutput selects the output format of command results.
+func SetOutput(format string) {
+	format = strings.ToLower(strings.TrimSpace(format))
+
+	isKnown := false
+	for _, knownFormat := range outputFormats {
+		if format == knownFormat {
+			isKnown = true
+			break
+		}
+	}
+
+	if !isKnown {
+		progressf("Unknown output [%s]. Use one of: %s\n", format, strings.Join(outputFormats, ", "))
+		os.Exit(1)
+	}
+
+	Output = format
+}
+
+// progressf prints progress and other messages, which aren't results.
+func progressf(format string, args ...interface{}) {
+	fmt.Fprintf(progressFile, format, args...)
+}
+
+// resultf prints a line of a text result. In structured output the records are
+// the results, so lines like counts are printed as progress.
+func resultf(format string, args ...interface{}) {
+	if Output == OutputText {
+		fmt.Fprintf(resultFile, format, args...)
+	} else {
+		progressf(format, args...)
+	}
+}
+
+// resultField is a named value of a result record. Records keep their field
+// order, so Json keys and CSV columns are stable.
+type resultField struct {
+	name  string
+	value interface{}
+}
+
+// resultWriter writes records in a structured Output format. Not used for text output.
+type resultWriter struct {
+	writer *bufio.Writer
+
+	csvWriter   *csv.Writer
+	recordCount int
+}
+
+func newResultWriter() *resultWriter {
+	results := &resultWriter{writer: bufio.NewWriter(resultFile)}
+
+	if Output == OutputCsv {
+		results.csvWriter = csv.NewWriter(results.writer)
+	}
+
+	return results
+}
+
+func (results *resultWriter) write(record ...resultField) {
+	switch Output {
+	case OutputCsv:
+		if results.recordCount == 0 {
+			var header []string
+			for _, field := range record {
+				header = append(header, field.name)
+			}
+
+			err := results.csvWriter.Write(header)
+			check(err, "Failed to write results")
+		}
+
+		var values []string
+		for _, field := range record {
+			values = append(values, fmt.Sprint(field.value))
+		}
+
+		err := results.csvWriter.Write(values)
+		check(err, "Failed to write results")
+
+	case OutputJson:
+		if results.recordCount == 0 {
+			results.writer.WriteString("[\n  ")
+		} else {
+			results.writer.WriteString(",\n  ")
+		}
+
+		results.writer.Write(recordJson(record))
+
+	case OutputNdjson:
+		results.writer.Write(recordJson(record))
+		results.writer.WriteString("\n")
+	}
+
+	results.recordCount++
+}
+
+func (results *resultWriter) close() {
+	switch Output {
+	case OutputCsv:
+		results.csvWriter.Flush()
+		check(results.csvWriter.Error(), "Failed to write results")
+
+	case OutputJson:
+		if results.recordCount == 0 {
+			results.writer.WriteString("[")
+		}
+
+		results.writer.WriteString("\n]\n")
+	}
+
+	err := results.writer.Flush()
+	check(err, "Failed to write results")
+}
+
+func recordJson(record []resultField) []byte {
+	var jsonRecord strings.Builder
+
+	jsonRecord.WriteString("{")
+
+	for i, field := range record {
+		if i > 0 {
+			jsonRecord.WriteString(", ")
+		}
+
+		name, err := json.Marshal(field.name)
+		check(err, "Failed to serialize result")
+
+		value, err := json.Marshal(field.value)
+		check(err, "Failed to serialize result")
+
+		jsonRecord.Write(name)
+		jsonRecord.WriteString(": ")
+		jsonRecord.Write(value)
+	}
+
+	jsonRecord.WriteString("}")
+
+	return []byte(jsonRecord.String())
+}
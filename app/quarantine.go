@@ -0,0 +1,77 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"unicode/utf8"
+)
+
+// quarantineEntry is a single line of the quarantine file.
+type quarantineEntry struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Error   string `json:"error"`
+	Content string `json:"content"`
+
+	// Set instead of Content, if the line isn't valid UTF-8
+	RawContent []byte `json:"rawContent,omitempty"`
+}
+
+// quarantine collects lines, which could not be imported. The file is only
+// created when the first line is quarantined.
+type quarantine struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+
+	lineCounter int
+}
+
+func newQuarantine(path string) *quarantine {
+	return &quarantine{path: path}
+}
+
+func (q *quarantine) add(fileName string, lineNumber int, line []byte, reason error) {
+	if q.file == nil {
+		file, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		check(err, "Failed to open quarantine file")
+
+		progressf("Writing bad lines to [%s]\n", q.path)
+
+		q.file = file
+		q.writer = bufio.NewWriter(file)
+	}
+
+	entry := quarantineEntry{
+		File:  fileName,
+		Line:  lineNumber,
+		Error: reason.Error(),
+	}
+
+	if utf8.Valid(line) {
+		entry.Content = string(line)
+	} else {
+		entry.RawContent = line
+	}
+
+	jsonString, err := json.Marshal(entry)
+	check(err, "Failed to serialize quarantine entry")
+
+	_, err = q.writer.Write(append(jsonString, '\n'))
+	check(err, "Failed to write quarantine file")
+
+	q.lineCounter++
+}
+
+func (q *quarantine) close() {
+	if q.file == nil {
+		return
+	}
+
+	err := q.writer.Flush()
+	check(err, "Failed to write quarantine file")
+
+	err = q.file.Close()
+	check(err, "Failed to close quarantine file")
+}
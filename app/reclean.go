@@ -0,0 +1,114 @@
+package app
+
+import (
+	"time"
+)
+
+// Number of comments recleaned per transaction
+const recleanBatchSize = 10000
+
+// Reclean derives CommentsContent, Links and Quotes again from the stored html, using the current normalizer.
+func Reclean() {
+	progressf("Recleaning comments...")
+
+	conn := openDatabase()
+	defer conn.Close()
+
+	totalCount := queryScalar(conn, "SELECT COUNT(*) FROM CommentsHtml")
+
+	if totalCount == 0 {
+		progressf("No comment html stored. Please import again.\n")
+		return
+	}
+	missingCount := queryScalar(conn, "SELECT COUNT(*) FROM Comments WHERE NOT EXISTS (SELECT 1 FROM CommentsHtml WHERE CommentsHtml.CommentId = Comments.CommentId)")
+
+	if missingCount > 0 {
+		progressf("%d comments were imported without html and are left unchanged\n", missingCount)
+	}
+
+	stmtSelect, err := conn.Prepare("SELECT CommentId, Html FROM CommentsHtml WHERE CommentId > ? ORDER BY CommentId LIMIT ?")
+	check(err, "Failed to create query statememt")
+	defer stmtSelect.Close()
+
+	stmtUpdate, err := conn.Prepare("UPDATE CommentsContent SET Content = ? WHERE rowid = ?")
+	check(err, "Failed to prepare statement")
+	defer stmtUpdate.Close()
+
+	extras := newCommentExtras(conn)
+	defer extras.close()
+
+	progressTime := time.Now()
+	progressIteration := 0
+	recleanedCount := 0
+	lastCommentId := 0
+
+	for {
+		// Load one batch first. Updating while stepping would keep the read open across commits.
+		var commentIds []int
+		var htmls []string
+
+		err = stmtSelect.Reset()
+		check(err, "Failed to reset statement")
+
+		err = stmtSelect.Bind(lastCommentId, recleanBatchSize)
+		check(err, "Failed to bind")
+
+		for {
+			hasRows, err := stmtSelect.Step()
+			check(err, "Failed to step")
+
+			if !hasRows {
+				break
+			}
+
+			var commentId int
+			var html string
+
+			err = stmtSelect.Scan(&commentId, &html)
+			check(err, "Failed to scan")
+
+			commentIds = append(commentIds, commentId)
+			htmls = append(htmls, html)
+		}
+
+		if len(commentIds) == 0 {
+			break
+		}
+
+		err = conn.Begin()
+		check(err, "Failed to start transaction")
+
+		for i, commentId := range commentIds {
+			comment := parseComment(htmls[i])
+
+			err = stmtUpdate.Exec(comment.text, commentId)
+			check(err, "Failed to update comment content")
+
+			extras.store(commentId, comment)
+		}
+
+		err = conn.Commit()
+		check(err, "Failed to commit transaction")
+
+		lastCommentId = commentIds[len(commentIds)-1]
+		recleanedCount += len(commentIds)
+
+		progressIteration += len(commentIds)
+		if time.Since(progressTime).Seconds() > 2 {
+			progressPerSeconds := float64(progressIteration) / time.Since(progressTime).Seconds()
+			progress := float64(recleanedCount) / float64(totalCount) * 100.0
+
+			progressf("Recleaned %d of %d / %.1f%% / %0.1f comments per sec\n", recleanedCount, totalCount, progress, progressPerSeconds)
+
+			progressTime = time.Now()
+			progressIteration = 0
+		}
+	}
+
+	progressf("Optimizing full text index...\n")
+
+	err = conn.Exec("INSERT INTO CommentsContent (CommentsContent) VALUES ('optimize')")
+	check(err, "Failed to optimize CommentsContent")
+
+	progressf("Recleaned comments: %d\n", recleanedCount)
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// RemoveFile deletes everything imported from a file, so a bad import can be undone.
+// Comments of other files, which answered removed items, wait for their parent again.
+func RemoveFile(fileName string) {
+	progressf("Removing [%s]...", fileName)
+
+	checkDatabaseExists()
+
+	conn := connectDatabase(true)
+	defer conn.Close()
+
+	createImportTempTables(conn)
+
+	// Without a journal, a failed remove can't be rolled back
+	if Durability == DurabilityFast {
+		setDurability(conn, DurabilitySafe)
+	}
+
+	err := conn.Begin()
+	check(err, "Failed to start transaction")
+
+	storyCount, commentCount, otherCount, isKnown := removeFileRows(conn, fileName)
+
+	if !isKnown {
+		err = conn.Rollback()
+		check(err, "Failed to rollback transaction")
+
+		progressf("Nothing was imported from [%s]\n", fileName)
+
+		// Directory imports store paths relative to -dir, file imports only the base name
+		if candidates := importedFilesNamed(conn, path.Base(filepath.ToSlash(fileName))); len(candidates) > 0 {
+			progressf("Imported files with this name: %s\n", strings.Join(candidates, ", "))
+		}
+
+		os.Exit(1)
+	}
+
+	resolveComments(conn)
+
+	err = conn.Commit()
+	check(err, "Failed to commit transaction")
+
+	progressf("Removed %d stories, %d comments and %d other items\n", storyCount, commentCount, otherCount)
+}
+
+// removeFileRows deletes the rows of a file from all tables. Comments of the
+// affected stories are reset, so resolveComments links them again. Must run in
+// a transaction. isKnown is false, if nothing was imported from the file.
+func removeFileRows(conn *sqlite3.Conn, fileName string) (storyCount int, commentCount int, otherCount int, isKnown bool) {
+	err := conn.Exec("DROP TABLE IF EXISTS temp.RemovedStories")
+	check(err, "Failed to drop RemovedStories table")
+
+	// Stories of the file and stories, which lose comments
+	err = conn.Exec(
+		"CREATE TABLE temp.RemovedStories AS "+
+			"SELECT StoryId FROM Stories WHERE File = ? UNION SELECT StoryId FROM Comments WHERE File = ? AND StoryId > 0",
+		fileName, fileName)
+	check(err, "Failed to create RemovedStories table")
+
+	// Derived from comments
+	for _, table := range []string{"CommentsHtml", "Links", "Quotes"} {
+		err = conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE CommentId IN (SELECT CommentId FROM Comments WHERE File = ?)", table), fileName)
+		check(err, fmt.Sprintf("Failed to delete from %s", table))
+	}
+
+	err = conn.Exec("DELETE FROM CommentsContent WHERE rowid IN (SELECT CommentId FROM Comments WHERE File = ?)", fileName)
+	check(err, "Failed to delete from CommentsContent")
+
+	err = conn.Exec("DELETE FROM Comments WHERE File = ?", fileName)
+	check(err, "Failed to delete comments")
+
+	commentCount = conn.Changes()
+
+	// Derived from stories
+	err = conn.Exec("DELETE FROM StoriesContent WHERE rowid IN (SELECT StoryId FROM Stories WHERE File = ?)", fileName)
+	check(err, "Failed to delete from StoriesContent")
+
+	err = conn.Exec("DELETE FROM StoryKids WHERE StoryId IN (SELECT StoryId FROM Stories WHERE File = ?)", fileName)
+	check(err, "Failed to delete from StoryKids")
+
+	err = conn.Exec("DELETE FROM Stories WHERE File = ?", fileName)
+	check(err, "Failed to delete stories")
+
+	storyCount = conn.Changes()
+
+	for _, table := range []string{"Jobs", "Polls", "PollOptions"} {
+		err = conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE File = ?", table), fileName)
+		check(err, fmt.Sprintf("Failed to delete from %s", table))
+
+		otherCount += conn.Changes()
+	}
+
+	err = conn.Exec("DELETE FROM ImportedFiles WHERE File = ?", fileName)
+	check(err, "Failed to delete imported file")
+
+	isKnown = conn.Changes() > 0 || storyCount > 0 || commentCount > 0 || otherCount > 0
+
+	// Orphan the remaining comments of affected stories. Their thread is resolved again.
+	err = conn.Exec("UPDATE Comments SET StoryId = 0, Thread = 0, Level = 0 WHERE StoryId IN (SELECT StoryId FROM temp.RemovedStories)")
+	check(err, "Failed to reset comments of removed stories")
+
+	err = conn.Exec("UPDATE Stories SET CommentCount = 0 WHERE StoryId IN (SELECT StoryId FROM temp.RemovedStories)")
+	check(err, "Failed to reset comment counts")
+
+	err = conn.Exec("DROP TABLE temp.RemovedStories")
+	check(err, "Failed to drop RemovedStories table")
+
+	return storyCount, commentCount, otherCount, isKnown
+}
+
+// importedFilesNamed returns the stored names of all imported files with the base name baseName.
+func importedFilesNamed(conn *sqlite3.Conn, baseName string) []string {
+	var fileNames []string
+
+	stmt, err := conn.Prepare(
+		"SELECT File FROM ImportedFiles UNION SELECT File FROM Stories UNION SELECT File FROM Comments ORDER BY 1")
+	check(err, "Failed to create query statememt")
+
+	defer stmt.Close()
+
+	for {
+		hasRows, err := stmt.Step()
+		check(err, "Failed to step")
+
+		if !hasRows {
+			break
+		}
+
+		var fileName string
+
+		err = stmt.Scan(&fileName)
+		check(err, "Failed to scan")
+
+		if path.Base(fileName) == baseName {
+			fileNames = append(fileNames, "["+fileName+"]")
+		}
+	}
+
+	return fileNames
+}
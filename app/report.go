@@ -0,0 +1,111 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// Status of a file in the import report
+const (
+	reportStatusImported = "imported"
+	reportStatusReplaced = "replaced"
+	reportStatusSkipped  = "skipped"
+)
+
+// importReport is written by import -report, so pipelines can check an import without parsing its output.
+type importReport struct {
+	Database        string              `json:"database"`
+	StartedAt       time.Time           `json:"startedAt"`
+	DurationSeconds float64             `json:"durationSeconds"`
+	Files           []importFileReport  `json:"files"`
+	Total           importCounterReport `json:"total"`
+
+	// Comments of the whole database after the import
+	CommentsWithoutStory     int `json:"commentsWithoutStory"`
+	CommentsWaitingForParent int `json:"commentsWaitingForParent"`
+}
+
+type importFileReport struct {
+	File            string              `json:"file"`
+	Status          string              `json:"status"`
+	Items           int                 `json:"items"`
+	DurationSeconds float64             `json:"durationSeconds"`
+	Counters        importCounterReport `json:"counters"`
+}
+
+type importCounterReport struct {
+	ReadStories       int `json:"readStories"`
+	NewStories        int `json:"newStories"`
+	DeletedStories    int `json:"deletedStories"`
+	EmptyStories      int `json:"emptyStories"`
+	AskHnStories      int `json:"askHnStories"`
+	ShowHnStories     int `json:"showHnStories"`
+	NoCommentsStories int `json:"noCommentsStories"`
+	ReadComments      int `json:"readComments"`
+	NewComments       int `json:"newComments"`
+	DeletedComments   int `json:"deletedComments"`
+	EmptyComments     int `json:"emptyComments"`
+	ReadJobs          int `json:"readJobs"`
+	NewJobs           int `json:"newJobs"`
+	ReadPolls         int `json:"readPolls"`
+	NewPolls          int `json:"newPolls"`
+	ReadPollOptions   int `json:"readPollOptions"`
+	NewPollOptions    int `json:"newPollOptions"`
+	QuarantinedLines  int `json:"quarantinedLines"`
+}
+
+func newImportReport() *importReport {
+	return &importReport{
+		Database:  DatabasePath,
+		StartedAt: time.Now(),
+		Files:     []importFileReport{},
+	}
+}
+
+// addFile reports the counters, which changed since countersBefore.
+func (report *importReport) addFile(fileName string, status string, itemCount int, counters importCounters, countersBefore importCounters, startTime time.Time) {
+	report.Files = append(report.Files, importFileReport{
+		File:            fileName,
+		Status:          status,
+		Items:           itemCount,
+		DurationSeconds: time.Since(startTime).Seconds(),
+		Counters:        newImportCounterReport(counters, countersBefore),
+	})
+}
+
+func (report *importReport) write(reportPath string, counters importCounters) {
+	report.DurationSeconds = time.Since(report.StartedAt).Seconds()
+	report.Total = newImportCounterReport(counters, importCounters{})
+
+	jsonString, err := json.MarshalIndent(report, "", "  ")
+	check(err, "Failed to serialize import report")
+
+	err = ioutil.WriteFile(reportPath, append(jsonString, '\n'), 0644)
+	check(err, "Failed to write import report")
+
+	progressf("Import report written to [%s]\n", reportPath)
+}
+
+func newImportCounterReport(counters importCounters, countersBefore importCounters) importCounterReport {
+	return importCounterReport{
+		ReadStories:       counters.readStoryCounter - countersBefore.readStoryCounter,
+		NewStories:        counters.newStoryCounter - countersBefore.newStoryCounter,
+		DeletedStories:    counters.deletedStoryCounter - countersBefore.deletedStoryCounter,
+		EmptyStories:      counters.emptyStoryCounter - countersBefore.emptyStoryCounter,
+		AskHnStories:      counters.askHnStoryCounter - countersBefore.askHnStoryCounter,
+		ShowHnStories:     counters.showHnStoryCounter - countersBefore.showHnStoryCounter,
+		NoCommentsStories: counters.noCommentsStoryCounter - countersBefore.noCommentsStoryCounter,
+		ReadComments:      counters.readCommentCounter - countersBefore.readCommentCounter,
+		NewComments:       counters.newCommentCounter - countersBefore.newCommentCounter,
+		DeletedComments:   counters.deletedCommentCounter - countersBefore.deletedCommentCounter,
+		EmptyComments:     counters.emptyCommentCounter - countersBefore.emptyCommentCounter,
+		ReadJobs:          counters.readJobCounter - countersBefore.readJobCounter,
+		NewJobs:           counters.newJobCounter - countersBefore.newJobCounter,
+		ReadPolls:         counters.readPollCounter - countersBefore.readPollCounter,
+		NewPolls:          counters.newPollCounter - countersBefore.newPollCounter,
+		ReadPollOptions:   counters.readPollOptionCounter - countersBefore.readPollOptionCounter,
+		NewPollOptions:    counters.newPollOptionCounter - countersBefore.newPollOptionCounter,
+		QuarantinedLines:  counters.quarantinedCounter - countersBefore.quarantinedCounter,
+	}
+}
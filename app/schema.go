@@ -0,0 +1,262 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+type migration struct {
+	version     int
+	description string
+	migrate     func(conn *sqlite3.Conn)
+}
+
+// migrations upgrade the schema step by step. Never change an existing
+// migration, append a new one. Databases created before schema_version existed
+// may already contain parts of any migration, so migrations must be idempotent.
+var migrations = []migration{
+	{1, "Stories, comments and full text index", migrateBaseTables},
+	{2, "Imported files", migrateImportedFiles},
+	{3, "Item metadata", migrateItemMetadata},
+	{4, "Story kinds, jobs and polls", migrateKindsJobsPolls},
+	{5, "Story kids", migrateStoryKids},
+	{6, "Comment html", migrateCommentsHtml},
+	{7, "Links and quotes", migrateLinksQuotes},
+	{8, "Imported files by name", migrateImportedFilesByName},
+}
+
+// Item metadata columns, which were added after the first databases were created.
+var storyMetadataColumns = []string{
+	"By TEXT",
+	"Time INTEGER",
+	"Score INTEGER",
+	"Url TEXT",
+	"Descendants INTEGER",
+	"Dead INTEGER",
+	"Text TEXT",
+}
+
+var commentMetadataColumns = []string{
+	"By TEXT",
+	"Time INTEGER",
+	"Dead INTEGER",
+}
+
+// Migrate upgrades the database to the latest schema version. With dryRun,
+// the pending migrations are only listed.
+func Migrate(dryRun bool) {
+	checkDatabaseExists()
+
+	conn := connectDatabase(false)
+	defer conn.Close()
+
+	migrateDatabase(conn, dryRun)
+}
+
+// migrateDatabase applies all pending migrations, each in its own transaction.
+func migrateDatabase(conn *sqlite3.Conn, dryRun bool) {
+	currentVersion := 0
+
+	if len(tableColumns(conn, "schema_version")) > 0 {
+		currentVersion = queryScalar(conn, "SELECT IFNULL(MAX(Version), 0) FROM schema_version")
+	} else if !dryRun {
+		err := conn.Exec("CREATE TABLE schema_version(Version INTEGER PRIMARY KEY, Description TEXT, AppliedAt INTEGER)")
+		check(err, "Failed to create schema_version table")
+	}
+
+	latestVersion := migrations[len(migrations)-1].version
+
+	if currentVersion > latestVersion {
+		progressf("Database schema version %d is newer than this program supports (%d)\n", currentVersion, latestVersion)
+		os.Exit(1)
+	}
+
+	if dryRun {
+		progressf("Schema version: %d of %d\n", currentVersion, latestVersion)
+	}
+
+	for _, migration := range migrations {
+		if migration.version <= currentVersion {
+			continue
+		}
+
+		if dryRun {
+			progressf("Pending migration %d: %s\n", migration.version, migration.description)
+			continue
+		}
+
+		progressf("Applying migration %d: %s\n", migration.version, migration.description)
+
+		err := conn.Begin()
+		check(err, "Failed to start transaction")
+
+		migration.migrate(conn)
+
+		err = conn.Exec("INSERT INTO schema_version (Version, Description, AppliedAt) Values(?, ?, ?)",
+			migration.version, migration.description, time.Now().Unix())
+		check(err, "Failed to record schema version")
+
+		err = conn.Commit()
+		check(err, "Failed to commit transaction")
+	}
+}
+
+func migrateBaseTables(conn *sqlite3.Conn) {
+	err := conn.Exec("CREATE TABLE IF NOT EXISTS Stories(StoryId INTEGER PRIMARY KEY, CommentCount INTEGER, File TEXT)")
+	check(err, "Failed to create Stories table")
+
+	err = conn.Exec("CREATE TABLE IF NOT EXISTS Comments(CommentId INTEGER PRIMARY KEY, StoryId INTEGER, Parent INTEGER, Thread INTEGER, Level INTEGER, File TEXT)")
+	check(err, "Failed to create Comments table")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS CommentsStoryIdIndex ON Comments(StoryId)")
+	check(err, "Failed to create Comments index")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS StoriesFileIndex ON Stories(File)")
+	check(err, "Failed to create Stories index")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS CommentsFileIndex ON Comments(File)")
+	check(err, "Failed to create Comments index")
+
+	err = conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS StoriesContent USING fts5(Content)")
+	check(err, "Failed to create StoriesContent table")
+
+	err = conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS CommentsContent USING fts5(Content)")
+	check(err, "Failed to create CommentsContent table")
+}
+
+func migrateImportedFiles(conn *sqlite3.Conn) {
+	err := conn.Exec("CREATE TABLE IF NOT EXISTS ImportedFiles(Hash TEXT PRIMARY KEY, File TEXT, Size INTEGER, ModTime INTEGER, ItemCount INTEGER, StoryCount INTEGER, CommentCount INTEGER, ImportedAt INTEGER)")
+	check(err, "Failed to create ImportedFiles table")
+}
+
+func migrateItemMetadata(conn *sqlite3.Conn) {
+	addMissingColumns(conn, "Stories", storyMetadataColumns)
+	addMissingColumns(conn, "Comments", commentMetadataColumns)
+}
+
+func migrateKindsJobsPolls(conn *sqlite3.Conn) {
+	addedColumns := addMissingColumns(conn, "Stories", []string{"Kind TEXT DEFAULT 'story'"})
+
+	if _, hasKey := addedColumns["Kind"]; hasKey {
+		// Ask HN stories weren't imported before Kind existed. Only Show HN needs a fix.
+		err := conn.Exec("UPDATE Stories SET Kind = ? WHERE StoryId IN (SELECT rowid FROM StoriesContent WHERE Content LIKE 'Show HN:%')", storyKindShow)
+		check(err, "Failed to set story kinds")
+	}
+
+	err := conn.Exec("CREATE TABLE IF NOT EXISTS Jobs(JobId INTEGER PRIMARY KEY, Title TEXT, Text TEXT, Url TEXT, By TEXT, Time INTEGER, Score INTEGER, Dead INTEGER, File TEXT)")
+	check(err, "Failed to create Jobs table")
+
+	err = conn.Exec("CREATE TABLE IF NOT EXISTS Polls(PollId INTEGER PRIMARY KEY, Title TEXT, Text TEXT, By TEXT, Time INTEGER, Score INTEGER, Descendants INTEGER, Dead INTEGER, File TEXT)")
+	check(err, "Failed to create Polls table")
+
+	err = conn.Exec("CREATE TABLE IF NOT EXISTS PollOptions(PollOptionId INTEGER PRIMARY KEY, PollId INTEGER, Position INTEGER, Text TEXT, By TEXT, Time INTEGER, Score INTEGER, Dead INTEGER, File TEXT)")
+	check(err, "Failed to create PollOptions table")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS PollOptionsPollIdIndex ON PollOptions(PollId)")
+	check(err, "Failed to create PollOptions index")
+}
+
+func migrateStoryKids(conn *sqlite3.Conn) {
+	err := conn.Exec("CREATE TABLE IF NOT EXISTS StoryKids(CommentId INTEGER PRIMARY KEY, StoryId INTEGER, Thread INTEGER)")
+	check(err, "Failed to create StoryKids table")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS StoryKidsStoryIdIndex ON StoryKids(StoryId)")
+	check(err, "Failed to create StoryKids index")
+}
+
+func migrateCommentsHtml(conn *sqlite3.Conn) {
+	err := conn.Exec("CREATE TABLE IF NOT EXISTS CommentsHtml(CommentId INTEGER PRIMARY KEY, Html TEXT)")
+	check(err, "Failed to create CommentsHtml table")
+}
+
+func migrateLinksQuotes(conn *sqlite3.Conn) {
+	err := conn.Exec("CREATE TABLE IF NOT EXISTS Links(CommentId INTEGER, Url TEXT, Domain TEXT)")
+	check(err, "Failed to create Links table")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS LinksCommentIdIndex ON Links(CommentId)")
+	check(err, "Failed to create Links index")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS LinksDomainIndex ON Links(Domain)")
+	check(err, "Failed to create Links index")
+
+	err = conn.Exec("CREATE TABLE IF NOT EXISTS Quotes(CommentId INTEGER, Text TEXT)")
+	check(err, "Failed to create Quotes table")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS QuotesCommentIdIndex ON Quotes(CommentId)")
+	check(err, "Failed to create Quotes index")
+}
+
+// migrateImportedFilesByName keys ImportedFiles by File, so files with the same
+// content, e.g. two empty dumps, each keep their row.
+func migrateImportedFilesByName(conn *sqlite3.Conn) {
+	err := conn.Exec("CREATE TABLE ImportedFilesByName(File TEXT PRIMARY KEY, Hash TEXT, Size INTEGER, ModTime INTEGER, ItemCount INTEGER, StoryCount INTEGER, CommentCount INTEGER, ImportedAt INTEGER)")
+	check(err, "Failed to create ImportedFilesByName table")
+
+	err = conn.Exec(
+		"INSERT OR REPLACE INTO ImportedFilesByName (File, Hash, Size, ModTime, ItemCount, StoryCount, CommentCount, ImportedAt) " +
+			"SELECT File, Hash, Size, ModTime, ItemCount, StoryCount, CommentCount, ImportedAt FROM ImportedFiles ORDER BY ImportedAt")
+	check(err, "Failed to copy ImportedFiles")
+
+	err = conn.Exec("DROP TABLE ImportedFiles")
+	check(err, "Failed to drop ImportedFiles table")
+
+	err = conn.Exec("ALTER TABLE ImportedFilesByName RENAME TO ImportedFiles")
+	check(err, "Failed to rename ImportedFilesByName table")
+
+	err = conn.Exec("CREATE INDEX IF NOT EXISTS ImportedFilesHashIndex ON ImportedFiles(Hash)")
+	check(err, "Failed to create ImportedFiles index")
+}
+
+// addMissingColumns returns the names of the added columns.
+func addMissingColumns(conn *sqlite3.Conn, table string, columns []string) map[string]struct{} {
+	addedColumns := make(map[string]struct{})
+	existingColumns := tableColumns(conn, table)
+
+	for _, column := range columns {
+		columnName := strings.Fields(column)[0]
+
+		if _, hasKey := existingColumns[columnName]; hasKey {
+			continue
+		}
+
+		progressf("Adding column %s.%s\n", table, columnName)
+
+		err := conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+		check(err, fmt.Sprintf("Failed to add column %s.%s", table, columnName))
+
+		addedColumns[columnName] = struct{}{}
+	}
+
+	return addedColumns
+}
+
+func tableColumns(conn *sqlite3.Conn, table string) map[string]struct{} {
+	columns := make(map[string]struct{})
+
+	stmt, err := conn.Prepare(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	check(err, "Failed to create query statememt")
+
+	defer stmt.Close()
+
+	var columnName string
+
+	for {
+		hasRows, err := stmt.Step()
+		check(err, "Failed to step")
+
+		if !hasRows {
+			break
+		}
+
+		err = stmt.Scan(nil, &columnName)
+		check(err, "Failed to scan")
+
+		columns[columnName] = struct{}{}
+	}
+
+	return columns
+}
@@ -0,0 +1,595 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The query language of query, rank -filter and the shell:
+//
+//	apple pie                 both words (AND is optional)
+//	"apple pie"               phrase
+//	apple OR pear             either word
+//	apple NOT pie             apple without pie
+//	(apple OR pear) AND pie   grouping
+//	NEAR(apple pie, 5)        words at most 5 tokens apart
+//	program*                  prefix
+//	level:<=2 story:123       predicates on comments: level, thread, story, by
+//
+// Words are quoted for FTS5, so hyphens, dots and colons are searched as text.
+// Predicates filter the whole query, so they can't be used inside OR, NOT or
+// parentheses. Operators are upper case, lower case "and" is a word.
+
+// queryField is a field of a predicate like level:<=2.
+type queryField struct {
+	commentColumn string
+
+	// Empty, if stories never match, e.g. for level
+	storyColumn string
+
+	isText bool
+}
+
+var queryFields = map[string]queryField{
+	"level":  {commentColumn: "Comments.Level"},
+	"thread": {commentColumn: "Comments.Thread"},
+	"story":  {commentColumn: "Comments.StoryId", storyColumn: "Stories.StoryId"},
+	"by":     {commentColumn: "Comments.By", storyColumn: "Stories.By", isText: true},
+}
+
+// Comparison operators of predicates, longest first
+var queryComparisons = []string{"<=", ">=", "!=", "<", ">", "="}
+
+type queryTokenKind int
+
+const (
+	queryTokenEnd queryTokenKind = iota
+	queryTokenWord
+	queryTokenPhrase
+	queryTokenOpen
+	queryTokenClose
+	queryTokenComma
+)
+
+type queryToken struct {
+	kind     queryTokenKind
+	text     string
+	position int
+
+	// A * directly after the word or phrase
+	isPrefix bool
+}
+
+// queryNode is a node of a parsed query. Leaves have no operator.
+type queryNode struct {
+	operator string
+	children []*queryNode
+
+	// FTS5 expression of a leaf
+	fts string
+
+	predicate *queryPredicate
+	position  int
+}
+
+type queryPredicate struct {
+	name       string
+	field      queryField
+	comparison string
+	value      interface{}
+}
+
+// searchQuery is a compiled query. Without text, only the predicates filter.
+type searchQuery struct {
+	match string
+
+	commentSql  []string
+	commentArgs []interface{}
+	storySql    []string
+	storyArgs   []interface{}
+
+	// A predicate, which stories never match
+	excludesStories bool
+}
+
+// parseSearchQueryOrExit prints a helpful error and exits, if the query is invalid.
+func parseSearchQueryOrExit(input string) *searchQuery {
+	query, err := parseSearchQuery(input)
+	if err != nil {
+		progressf("\n")
+		progressf("%s\n", err)
+		os.Exit(1)
+	}
+
+	return query
+}
+
+// parseSearchQuery parses and validates a query and compiles it to a MATCH expression and SQL conditions.
+func parseSearchQuery(input string) (*searchQuery, error) {
+	parser := &queryParser{input: input}
+
+	err := parser.tokenize()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.peek().kind == queryTokenEnd {
+		return nil, parser.errorAt(0, "Empty query")
+	}
+
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.kind != queryTokenEnd {
+		if token.kind == queryTokenClose {
+			return nil, parser.errorAt(token.position, "Unexpected ) without (")
+		}
+
+		if token.kind == queryTokenComma {
+			return nil, parser.errorAt(token.position, "Unexpected , outside of NEAR")
+		}
+
+		return nil, parser.errorAt(token.position, "Unexpected [%s]", token.text)
+	}
+
+	return parser.compile(root)
+}
+
+type queryParser struct {
+	input  string
+	tokens []queryToken
+	index  int
+}
+
+// errorAt returns an error, which points at position in the query.
+func (parser *queryParser) errorAt(position int, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+
+	column := len([]rune(parser.input[:position]))
+
+	return fmt.Errorf("Invalid query: %s\n  %s\n  %s^", message, parser.input, strings.Repeat(" ", column))
+}
+
+func (parser *queryParser) tokenize() error {
+	input := parser.input
+
+	for position := 0; position < len(input); {
+		char := input[position]
+
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r':
+			position++
+
+		case char == '(':
+			parser.tokens = append(parser.tokens, queryToken{kind: queryTokenOpen, text: "(", position: position})
+			position++
+
+		case char == ')':
+			parser.tokens = append(parser.tokens, queryToken{kind: queryTokenClose, text: ")", position: position})
+			position++
+
+		case char == ',':
+			parser.tokens = append(parser.tokens, queryToken{kind: queryTokenComma, text: ",", position: position})
+			position++
+
+		case char == '"':
+			end := strings.IndexByte(input[position+1:], '"')
+			if end < 0 {
+				return parser.errorAt(position, "Missing closing quote of this phrase")
+			}
+
+			token := queryToken{kind: queryTokenPhrase, text: input[position+1 : position+1+end], position: position}
+			position += end + 2
+
+			if position < len(input) && input[position] == '*' {
+				token.isPrefix = true
+				position++
+			}
+
+			if strings.TrimSpace(token.text) == "" {
+				return parser.errorAt(token.position, "Empty phrase")
+			}
+
+			parser.tokens = append(parser.tokens, token)
+
+		default:
+			end := position
+			for end < len(input) && !strings.ContainsRune(" \t\n\r()\",", rune(input[end])) {
+				end++
+			}
+
+			token := queryToken{kind: queryTokenWord, text: input[position:end], position: position}
+			position = end
+
+			if strings.HasSuffix(token.text, "*") {
+				token.text = strings.TrimRight(token.text, "*")
+				token.isPrefix = true
+
+				if token.text == "" {
+					return parser.errorAt(token.position, "* needs a word in front of it, e.g. program*")
+				}
+			}
+
+			parser.tokens = append(parser.tokens, token)
+		}
+	}
+
+	parser.tokens = append(parser.tokens, queryToken{kind: queryTokenEnd, position: len(input)})
+
+	return nil
+}
+
+func (parser *queryParser) peek() queryToken {
+	return parser.tokens[parser.index]
+}
+
+func (parser *queryParser) next() queryToken {
+	token := parser.tokens[parser.index]
+
+	if token.kind != queryTokenEnd {
+		parser.index++
+	}
+
+	return token
+}
+
+func (parser *queryParser) isOperator(token queryToken, operator string) bool {
+	return token.kind == queryTokenWord && !token.isPrefix && token.text == operator
+}
+
+func (parser *queryParser) parseOr() (*queryNode, error) {
+	return parser.parseBinary("OR", parser.parseAnd)
+}
+
+func (parser *queryParser) parseAnd() (*queryNode, error) {
+	first, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &queryNode{operator: "AND", children: []*queryNode{first}, position: first.position}
+
+	for {
+		token := parser.peek()
+
+		if token.kind == queryTokenEnd || token.kind == queryTokenClose || token.kind == queryTokenComma || parser.isOperator(token, "OR") {
+			break
+		}
+
+		// AND is optional between terms
+		if parser.isOperator(token, "AND") {
+			parser.next()
+
+			if next := parser.peek(); next.kind == queryTokenEnd || next.kind == queryTokenClose {
+				return nil, parser.errorAt(next.position, "Missing term after AND")
+			}
+		}
+
+		child, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		node.children = append(node.children, child)
+	}
+
+	if len(node.children) == 1 {
+		return first, nil
+	}
+
+	return node, nil
+}
+
+func (parser *queryParser) parseNot() (*queryNode, error) {
+	if token := parser.peek(); parser.isOperator(token, "NOT") {
+		return nil, parser.errorAt(token.position, "NOT needs a term on its left, e.g. apple NOT pie")
+	}
+
+	return parser.parseBinary("NOT", parser.parseTerm)
+}
+
+// parseBinary parses operands separated by operator.
+func (parser *queryParser) parseBinary(operator string, parseOperand func() (*queryNode, error)) (*queryNode, error) {
+	first, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	node := first
+
+	for parser.isOperator(parser.peek(), operator) {
+		operatorToken := parser.next()
+
+		if next := parser.peek(); next.kind == queryTokenEnd || next.kind == queryTokenClose || next.kind == queryTokenComma {
+			return nil, parser.errorAt(next.position, "Missing term after %s", operator)
+		}
+
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+
+		node = &queryNode{operator: operator, children: []*queryNode{node, right}, position: operatorToken.position}
+	}
+
+	return node, nil
+}
+
+func (parser *queryParser) parseTerm() (*queryNode, error) {
+	token := parser.next()
+
+	switch token.kind {
+	case queryTokenOpen:
+		if next := parser.peek(); next.kind == queryTokenClose {
+			return nil, parser.errorAt(next.position, "Empty parentheses")
+		}
+
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if next := parser.next(); next.kind != queryTokenClose {
+			return nil, parser.errorAt(token.position, "Missing ) for this (")
+		}
+
+		// Parentheses are kept, so predicates inside them are rejected
+		return &queryNode{operator: "()", children: []*queryNode{node}, position: token.position}, nil
+
+	case queryTokenPhrase:
+		return &queryNode{fts: ftsString(token.text, token.isPrefix), position: token.position}, nil
+
+	case queryTokenWord:
+		if parser.isOperator(token, "AND") || parser.isOperator(token, "OR") {
+			return nil, parser.errorAt(token.position, "%s needs a term on its left", token.text)
+		}
+
+		if token.text == "NEAR" && parser.peek().kind == queryTokenOpen {
+			return parser.parseNear(token)
+		}
+
+		if colon := strings.IndexByte(token.text, ':'); colon > 0 && !token.isPrefix {
+			return parser.parsePredicate(token, colon)
+		}
+
+		return &queryNode{fts: ftsString(token.text, token.isPrefix), position: token.position}, nil
+
+	case queryTokenClose:
+		return nil, parser.errorAt(token.position, "Unexpected ) without (")
+
+	case queryTokenComma:
+		return nil, parser.errorAt(token.position, "Unexpected , outside of NEAR")
+	}
+
+	return nil, parser.errorAt(token.position, "Missing term")
+}
+
+// parseNear parses NEAR(term term ..., distance).
+func (parser *queryParser) parseNear(nearToken queryToken) (*queryNode, error) {
+	parser.next()
+
+	var terms []string
+	distance := ""
+
+	for {
+		token := parser.next()
+
+		switch token.kind {
+		case queryTokenWord, queryTokenPhrase:
+			terms = append(terms, ftsString(token.text, token.isPrefix))
+			continue
+
+		case queryTokenComma:
+			distanceToken := parser.next()
+
+			if _, err := strconv.Atoi(distanceToken.text); distanceToken.kind != queryTokenWord || err != nil || distanceToken.isPrefix {
+				return nil, parser.errorAt(distanceToken.position, "NEAR needs a number as distance, e.g. NEAR(apple pie, 5)")
+			}
+
+			distance = ", " + distanceToken.text
+
+			if closeToken := parser.next(); closeToken.kind != queryTokenClose {
+				return nil, parser.errorAt(closeToken.position, "Missing ) after the distance of NEAR")
+			}
+
+		case queryTokenClose:
+
+		default:
+			return nil, parser.errorAt(nearToken.position, "Missing ) for NEAR")
+		}
+
+		break
+	}
+
+	if len(terms) < 2 {
+		return nil, parser.errorAt(nearToken.position, "NEAR needs at least two terms, e.g. NEAR(apple pie)")
+	}
+
+	return &queryNode{fts: "NEAR(" + strings.Join(terms, " ") + distance + ")", position: nearToken.position}, nil
+}
+
+// parsePredicate parses field:value and field:<=value.
+func (parser *queryParser) parsePredicate(token queryToken, colon int) (*queryNode, error) {
+	name := strings.ToLower(token.text[:colon])
+	value := token.text[colon+1:]
+
+	field, isKnown := queryFields[name]
+	if !isKnown {
+		return nil, parser.errorAt(token.position,
+			"Unknown field [%s]. Use one of: %s. Put it in quotes to search for the text.", token.text[:colon], strings.Join(queryFieldNames(), ", "))
+	}
+
+	predicate := &queryPredicate{name: name, field: field, comparison: "="}
+
+	for _, comparison := range queryComparisons {
+		if strings.HasPrefix(value, comparison) {
+			predicate.comparison = comparison
+			value = value[len(comparison):]
+			break
+		}
+	}
+
+	valuePosition := token.position + len(token.text) - len(value)
+
+	if value == "" {
+		return nil, parser.errorAt(valuePosition, "Missing value for %s, e.g. %s", name, queryFieldExample(name))
+	}
+
+	if field.isText {
+		if predicate.comparison != "=" && predicate.comparison != "!=" {
+			return nil, parser.errorAt(token.position+colon+1, "%s only supports = and !=, e.g. %s", name, queryFieldExample(name))
+		}
+
+		predicate.value = value
+	} else {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, parser.errorAt(valuePosition, "Invalid number [%s] for %s, e.g. %s", value, name, queryFieldExample(name))
+		}
+
+		predicate.value = number
+	}
+
+	return &queryNode{predicate: predicate, position: token.position}, nil
+}
+
+func queryFieldNames() []string {
+	var names []string
+	for name := range queryFields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func queryFieldExample(name string) string {
+	switch name {
+	case "by":
+		return "by:pg"
+	case "story":
+		return "story:123"
+	}
+
+	return name + ":<=2"
+}
+
+// compile splits the top level AND into predicates and the MATCH expression.
+func (parser *queryParser) compile(root *queryNode) (*searchQuery, error) {
+	query := &searchQuery{}
+
+	terms := []*queryNode{root}
+	if root.operator == "AND" {
+		terms = root.children
+	}
+
+	var matchTerms []string
+
+	for _, term := range terms {
+		if term.predicate != nil {
+			query.addPredicate(term.predicate)
+			continue
+		}
+
+		fts, err := parser.compileFts(term)
+		if err != nil {
+			return nil, err
+		}
+
+		matchTerms = append(matchTerms, fts)
+	}
+
+	query.match = strings.Join(matchTerms, " AND ")
+
+	return query, nil
+}
+
+func (parser *queryParser) compileFts(node *queryNode) (string, error) {
+	if node.predicate != nil {
+		return "", parser.errorAt(node.position, "Predicates like %s:... filter the whole query. Use them outside of OR, NOT and parentheses.", node.predicate.name)
+	}
+
+	if node.operator == "" {
+		return node.fts, nil
+	}
+
+	var children []string
+
+	for _, child := range node.children {
+		fts, err := parser.compileFts(child)
+		if err != nil {
+			return "", err
+		}
+
+		children = append(children, fts)
+	}
+
+	if node.operator == "()" {
+		return children[0], nil
+	}
+
+	return "(" + strings.Join(children, " "+node.operator+" ") + ")", nil
+}
+
+func (query *searchQuery) addPredicate(predicate *queryPredicate) {
+	query.commentSql = append(query.commentSql, predicate.field.commentColumn+" "+predicate.comparison+" ?")
+	query.commentArgs = append(query.commentArgs, predicate.value)
+
+	if predicate.field.storyColumn == "" {
+		query.excludesStories = true
+		return
+	}
+
+	query.storySql = append(query.storySql, predicate.field.storyColumn+" "+predicate.comparison+" ?")
+	query.storyArgs = append(query.storyArgs, predicate.value)
+}
+
+// commentCondition returns the SQL condition on Comments and CommentsContent and its arguments.
+func (query *searchQuery) commentCondition() (string, []interface{}) {
+	return query.condition("CommentsContent", query.commentSql, query.commentArgs)
+}
+
+// storyCondition returns the SQL condition on Stories and StoriesContent and its arguments.
+func (query *searchQuery) storyCondition() (string, []interface{}) {
+	if query.excludesStories {
+		return "0", nil
+	}
+
+	return query.condition("StoriesContent", query.storySql, query.storyArgs)
+}
+
+func (query *searchQuery) condition(contentTable string, predicateSql []string, predicateArgs []interface{}) (string, []interface{}) {
+	// Copied, so callers can append their own arguments
+	conditions := append([]string{}, predicateSql...)
+	args := append([]interface{}{}, predicateArgs...)
+
+	if query.match != "" {
+		conditions = append([]string{contentTable + ".Content MATCH ?"}, conditions...)
+		args = append([]interface{}{query.match}, args...)
+	}
+
+	if len(conditions) == 0 {
+		return "1", nil
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// ftsString quotes text as FTS5 string, so it's never parsed as FTS5 syntax.
+func ftsString(text string, isPrefix bool) string {
+	fts := "\"" + strings.Replace(text, "\"", "\"\"", -1) + "\""
+
+	// A prefix needs a token. A word like "-" would match everything otherwise.
+	if isPrefix && strings.IndexFunc(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) >= 0 {
+		fts += " *"
+	}
+
+	return fts
+}
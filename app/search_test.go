@@ -0,0 +1,260 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		match       string
+		commentSql  []string
+		commentArgs []interface{}
+	}{
+		{
+			name:  "word",
+			query: "sky",
+			match: `"sky"`,
+		},
+		{
+			name:  "phrase",
+			query: `"blue sky"`,
+			match: `"blue sky"`,
+		},
+		{
+			name:  "phrase and word",
+			query: `"blue sky" today`,
+			match: `"blue sky" AND "today"`,
+		},
+		{
+			name:  "implicit AND",
+			query: "sky grey",
+			match: `"sky" AND "grey"`,
+		},
+		{
+			name:  "AND",
+			query: "sky AND grey",
+			match: `"sky" AND "grey"`,
+		},
+		{
+			name:  "OR",
+			query: "sky OR grey",
+			match: `("sky" OR "grey")`,
+		},
+		{
+			name:  "NOT",
+			query: "sky NOT grey",
+			match: `("sky" NOT "grey")`,
+		},
+		{
+			name:  "parentheses",
+			query: "(rust OR go) NOT java",
+			match: `(("rust" OR "go") NOT "java")`,
+		},
+		{
+			name:  "NEAR with distance",
+			query: "NEAR(a b, 5)",
+			match: `NEAR("a" "b", 5)`,
+		},
+		{
+			name:  "NEAR with phrase",
+			query: `NEAR(a "b c")`,
+			match: `NEAR("a" "b c")`,
+		},
+		{
+			name:  "prefix",
+			query: "prog*",
+			match: `"prog" *`,
+		},
+		{
+			name:  "hyphen",
+			query: "foo-bar",
+			match: `"foo-bar"`,
+		},
+		{
+			name:        "predicates only",
+			query:       "level:<=2 story:123",
+			commentSql:  []string{"Comments.Level <= ?", "Comments.StoryId = ?"},
+			commentArgs: []interface{}{2, 123},
+		},
+		{
+			name:        "words and predicates",
+			query:       "rust level:1 thread:>3 by:pg",
+			match:       `"rust"`,
+			commentSql:  []string{"Comments.Level = ?", "Comments.Thread > ?", "Comments.By = ?"},
+			commentArgs: []interface{}{1, 3, "pg"},
+		},
+		{
+			name:        "not equal",
+			query:       "level:!=2 sky",
+			match:       `"sky"`,
+			commentSql:  []string{"Comments.Level != ?"},
+			commentArgs: []interface{}{2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := parseSearchQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if query.match != test.match {
+				t.Errorf("match\n got: %s\nwant: %s", query.match, test.match)
+			}
+
+			if len(query.commentSql) > 0 || len(test.commentSql) > 0 {
+				if !reflect.DeepEqual(query.commentSql, test.commentSql) {
+					t.Errorf("commentSql\n got: %q\nwant: %q", query.commentSql, test.commentSql)
+				}
+
+				if !reflect.DeepEqual(query.commentArgs, test.commentArgs) {
+					t.Errorf("commentArgs\n got: %v\nwant: %v", query.commentArgs, test.commentArgs)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchQueryConditions(t *testing.T) {
+	query, err := parseSearchQuery("sky level:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commentSql, commentArgs := query.commentCondition()
+	if commentSql != "CommentsContent.Content MATCH ? AND Comments.Level = ?" || !reflect.DeepEqual(commentArgs, []interface{}{`"sky"`, 1}) {
+		t.Errorf("Comment condition [%s] %v", commentSql, commentArgs)
+	}
+
+	// Stories have no level
+	storySql, _ := query.storyCondition()
+	if storySql != "0" {
+		t.Errorf("Story condition [%s], expected no stories", storySql)
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		message string
+		column  int
+	}{
+		{
+			name:    "unclosed quote",
+			query:   `sky "blue day`,
+			message: "Missing closing quote of this phrase",
+			column:  4,
+		},
+		{
+			name:    "unclosed quote after umlaut",
+			query:   `grün "x`,
+			message: "Missing closing quote of this phrase",
+			column:  5,
+		},
+		{
+			name:    "empty parentheses",
+			query:   "sky ()",
+			message: "Empty parentheses",
+			column:  5,
+		},
+		{
+			name:    "leading NOT",
+			query:   "NOT sky",
+			message: "NOT needs a term on its left, e.g. apple NOT pie",
+			column:  0,
+		},
+		{
+			name:    "unknown field",
+			query:   "sky foo:bar",
+			message: "Unknown field [foo]. Use one of: by, level, story, thread. Put it in quotes to search for the text.",
+			column:  4,
+		},
+		{
+			name:    "predicate inside OR",
+			query:   "sky OR level:2",
+			message: "Predicates like level:... filter the whole query. Use them outside of OR, NOT and parentheses.",
+			column:  7,
+		},
+		{
+			name:    "predicate inside parentheses",
+			query:   "(level:2)",
+			message: "Predicates like level:... filter the whole query. Use them outside of OR, NOT and parentheses.",
+			column:  1,
+		},
+		{
+			name:    "missing term after AND",
+			query:   "sky AND",
+			message: "Missing term after AND",
+			column:  7,
+		},
+		{
+			name:    "missing )",
+			query:   "(sky grey",
+			message: "Missing ) for this (",
+			column:  0,
+		},
+		{
+			name:    "unexpected )",
+			query:   "sky)",
+			message: "Unexpected ) without (",
+			column:  3,
+		},
+		{
+			name:    "NEAR with one term",
+			query:   "NEAR(sky)",
+			message: "NEAR needs at least two terms, e.g. NEAR(apple pie)",
+			column:  0,
+		},
+		{
+			name:    "NEAR distance",
+			query:   "NEAR(a b, x)",
+			message: "NEAR needs a number as distance, e.g. NEAR(apple pie, 5)",
+			column:  10,
+		},
+		{
+			name:    "lone star",
+			query:   "*",
+			message: "* needs a word in front of it, e.g. program*",
+			column:  0,
+		},
+		{
+			name:    "empty query",
+			query:   "  ",
+			message: "Empty query",
+			column:  0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseSearchQuery(test.query)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+
+			// Message, the query and a caret below the position
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("Unexpected error format: %q", err.Error())
+			}
+
+			if lines[0] != "Invalid query: "+test.message {
+				t.Errorf("message\n got: %s\nwant: Invalid query: %s", lines[0], test.message)
+			}
+
+			if lines[1] != "  "+test.query {
+				t.Errorf("Query line [%s]", lines[1])
+			}
+
+			if column := len([]rune(lines[2])) - 3; column != test.column || !strings.HasSuffix(lines[2], "^") {
+				t.Errorf("Caret at column %d, expected %d:\n%s", column, test.column, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/peterh/liner"
+)
+
+// Number of history lines kept in the history file
+const shellHistorySize = 1000
+
+const shellHelp = `Commands:
+  count <query>          Number of matching stories and comments
+  search <query>         List the best matching comments
+  more                   Next page of the last search
+  thread <id> [depth]    Print a story or the replies to a comment
+  kinds [story,ask,...]  Story kinds for count and search. Empty selects all.
+  limit <n>              Comments per page
+  full on|off            Whole comments instead of snippets
+  load <word map>        Load a word map written by rank
+  talk [start words]     Talk with the loaded word map
+  history                List the previous commands
+  !!, !<n>, !<prefix>    Run a previous command again
+  Up, Down, Ctrl-R       Recall and edit previous commands on a terminal
+  help                   This text
+  quit                   Leave the shell
+`
+
+// ShellOptions configures Shell.
+type ShellOptions struct {
+	// Commands are appended here and read again on start. Empty disables the history file.
+	HistoryPath string
+
+	// Word map loaded on start for talk. Optional.
+	WordConfigPath string
+
+	// Comments per page of search
+	Limit int
+}
+
+// shell keeps the database open between commands.
+type shell struct {
+	conn *sqlite3.Conn
+
+	kinds []string
+	limit int
+	full  bool
+
+	// Paging of the last search
+	lastQuery  *searchQuery
+	lastOffset int
+
+	model *talkModel
+
+	history     []string
+	historyPath string
+}
+
+// Shell reads commands from stdin until quit or the end of the input.
+func Shell(options ShellOptions) {
+	conn := openDatabase()
+	defer conn.Close()
+
+	sh := &shell{conn: conn, limit: options.Limit, historyPath: options.HistoryPath}
+
+	if sh.limit < 1 {
+		sh.limit = 10
+	}
+
+	if options.WordConfigPath != "" {
+		model, err := loadTalkModel(options.WordConfigPath)
+		check(err, "Failed to load word map")
+
+		sh.model = &model
+	}
+
+	sh.loadHistory()
+
+	input := newShellInput(sh.history)
+	defer input.close()
+
+	progressf("Type help for a list of commands.\n")
+
+	for {
+		line, err := input.readLine("hb> ")
+		if err != nil && err != io.EOF {
+			input.close()
+			check(err, "Failed to read command")
+		}
+
+		if err == io.EOF && line == "" {
+			progressf("\n")
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		line, err = sh.expandHistory(line)
+		if err != nil {
+			progressf("%s\n", err)
+			continue
+		}
+
+		sh.addHistory(line)
+		input.addHistory(line)
+
+		quit, err := sh.execute(line)
+		if err != nil {
+			progressf("Error: %s\n", err)
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+// execute runs a single command. Errors are printed and the shell continues.
+func (sh *shell) execute(line string) (bool, error) {
+	command := line
+	argument := ""
+
+	if index := strings.IndexAny(line, " \t"); index >= 0 {
+		command = line[:index]
+		argument = strings.TrimSpace(line[index+1:])
+	}
+
+	switch strings.ToLower(command) {
+	case "quit", "exit":
+		return true, nil
+
+	case "help", "?":
+		resultf("%s", shellHelp)
+
+	case "count":
+		if argument == "" {
+			return false, fmt.Errorf("Usage: count <query>")
+		}
+
+		query, err := parseSearchQuery(argument)
+		if err != nil {
+			return false, err
+		}
+
+		storiesFound, commentsFound, err := countMatches(sh.conn, query, sh.kinds)
+		if err != nil {
+			return false, err
+		}
+
+		resultf("Stories: %d\n", storiesFound)
+		resultf("Comments: %d\n", commentsFound)
+
+	case "search":
+		if argument == "" {
+			return false, fmt.Errorf("Usage: search <query>")
+		}
+
+		query, err := parseSearchQuery(argument)
+		if err != nil {
+			return false, err
+		}
+
+		sh.lastQuery = query
+		sh.lastOffset = 0
+
+		return false, searchComments(sh.conn, sh.lastQuery, sh.kinds, sh.limit, sh.lastOffset, sh.full)
+
+	case "more":
+		if sh.lastQuery == nil {
+			return false, fmt.Errorf("No search yet")
+		}
+
+		sh.lastOffset += sh.limit
+
+		return false, searchComments(sh.conn, sh.lastQuery, sh.kinds, sh.limit, sh.lastOffset, sh.full)
+
+	case "thread":
+		return false, sh.thread(argument)
+
+	case "kinds":
+		kinds, err := parseKinds(argument)
+		if err != nil {
+			return false, err
+		}
+
+		sh.kinds = kinds
+
+		if len(kinds) == 0 {
+			progressf("Using all kinds\n")
+		} else {
+			progressf("Using kinds: %s\n", strings.Join(kinds, ", "))
+		}
+
+	case "limit":
+		limit, err := strconv.Atoi(argument)
+		if err != nil || limit < 1 {
+			return false, fmt.Errorf("Usage: limit <n>")
+		}
+
+		sh.limit = limit
+
+	case "full":
+		switch strings.ToLower(argument) {
+		case "on":
+			sh.full = true
+		case "off":
+			sh.full = false
+		default:
+			return false, fmt.Errorf("Usage: full on|off")
+		}
+
+	case "load":
+		if argument == "" {
+			return false, fmt.Errorf("Usage: load <word map>")
+		}
+
+		if !fileExists(argument) {
+			return false, fmt.Errorf("Word map [%s] doesn't exist", argument)
+		}
+
+		model, err := loadTalkModel(argument)
+		if err != nil {
+			return false, err
+		}
+
+		sh.model = &model
+
+	case "talk":
+		if sh.model == nil {
+			return false, fmt.Errorf("No word map. Use load <word map> first.")
+		}
+
+		randInit := rand.New(rand.NewSource(time.Now().UnixNano()))
+		randTalk := rand.New(rand.NewSource(randInit.Int63()))
+
+		createTalk(sh.model.words, sh.model.wordKeys, sh.model.wordMap, 3, 0, argument, randInit, randTalk, false)
+
+	case "history":
+		for i, historyLine := range sh.history {
+			resultf("%5d  %s\n", i+1, historyLine)
+		}
+
+	default:
+		return false, fmt.Errorf("Unknown command [%s]. Type help for a list of commands.", command)
+	}
+
+	return false, nil
+}
+
+// thread prints a story, if id is one, and the replies to a comment otherwise.
+func (sh *shell) thread(argument string) error {
+	fields := strings.Fields(argument)
+
+	if len(fields) < 1 || len(fields) > 2 {
+		return fmt.Errorf("Usage: thread <id> [depth]")
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("Invalid id [%s]", fields[0])
+	}
+
+	options := ThreadOptions{Format: ThreadText}
+
+	if len(fields) == 2 {
+		options.MaxDepth, err = strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("Invalid depth [%s]", fields[1])
+		}
+	}
+
+	if queryScalar(sh.conn, "SELECT COUNT(*) FROM Stories WHERE StoryId = ?", id) > 0 {
+		options.StoryId = id
+	} else {
+		options.CommentId = id
+	}
+
+	return printThread(sh.conn, options)
+}
+
+// shellInput reads commands. On a terminal, lines can be edited and up and
+// down recall the history. Piped commands are read line by line.
+type shellInput struct {
+	reader *bufio.Reader
+
+	editor *liner.State
+
+	// The editor switches the terminal to raw mode. Commands run in the
+	// normal mode, so one that exits leaves the terminal usable.
+	normalMode liner.ModeApplier
+	editorMode liner.ModeApplier
+}
+
+func newShellInput(history []string) *shellInput {
+	input := &shellInput{}
+
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		input.reader = bufio.NewReader(os.Stdin)
+		return input
+	}
+
+	normalMode, err := liner.TerminalMode()
+	check(err, "Failed to get terminal mode")
+
+	input.editor = liner.NewLiner()
+	input.editor.SetCtrlCAborts(true)
+
+	input.editorMode, err = liner.TerminalMode()
+	check(err, "Failed to get terminal mode")
+
+	input.normalMode = normalMode
+	input.normalMode.ApplyMode()
+
+	for _, historyLine := range history {
+		input.editor.AppendHistory(historyLine)
+	}
+
+	return input
+}
+
+func (input *shellInput) readLine(prompt string) (string, error) {
+	if input.editor == nil {
+		progressf("%s", prompt)
+		return input.reader.ReadString('\n')
+	}
+
+	input.editorMode.ApplyMode()
+	defer input.normalMode.ApplyMode()
+
+	line, err := input.editor.Prompt(prompt)
+	if err == liner.ErrPromptAborted {
+		// Ctrl-C drops the line like in bash
+		return "", nil
+	}
+
+	return line, err
+}
+
+func (input *shellInput) addHistory(line string) {
+	if input.editor != nil {
+		input.editor.AppendHistory(line)
+	}
+}
+
+func (input *shellInput) close() {
+	if input.editor != nil {
+		input.editor.Close()
+		input.editor = nil
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// expandHistory replaces !!, !<n> and !<prefix> by a previous command like bash does.
+func (sh *shell) expandHistory(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+
+	reference := line[1:]
+
+	if len(sh.history) == 0 {
+		return "", fmt.Errorf("History is empty")
+	}
+
+	var expanded string
+
+	if reference == "!" {
+		expanded = sh.history[len(sh.history)-1]
+	} else if number, err := strconv.Atoi(reference); err == nil {
+		if number < 1 || number > len(sh.history) {
+			return "", fmt.Errorf("No command %d in history", number)
+		}
+
+		expanded = sh.history[number-1]
+	} else {
+		for i := len(sh.history) - 1; i >= 0; i-- {
+			if strings.HasPrefix(sh.history[i], reference) {
+				expanded = sh.history[i]
+				break
+			}
+		}
+
+		if expanded == "" {
+			return "", fmt.Errorf("No command starting with [%s] in history", reference)
+		}
+	}
+
+	progressf("%s\n", expanded)
+
+	return expanded, nil
+}
+
+func (sh *shell) loadHistory() {
+	if sh.historyPath == "" || !fileExists(sh.historyPath) {
+		return
+	}
+
+	file, err := os.Open(sh.historyPath)
+	check(err, "Failed to open history")
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			sh.history = append(sh.history, line)
+		}
+	}
+
+	check(scanner.Err(), "Failed to read history")
+
+	err = file.Close()
+	check(err, "Failed to close history")
+
+	if len(sh.history) <= shellHistorySize {
+		return
+	}
+
+	// Shortened once on start, so appending stays cheap
+	sh.history = sh.history[len(sh.history)-shellHistorySize:]
+
+	err = ioutil.WriteFile(sh.historyPath, []byte(strings.Join(sh.history, "\n")+"\n"), 0600)
+	check(err, "Failed to write history")
+}
+
+// addHistory remembers a command. It's appended to the history file at once,
+// so it isn't lost when the shell is killed.
+func (sh *shell) addHistory(line string) {
+	if len(sh.history) > 0 && sh.history[len(sh.history)-1] == line {
+		return
+	}
+
+	sh.history = append(sh.history, line)
+
+	if sh.historyPath == "" {
+		return
+	}
+
+	file, err := os.OpenFile(sh.historyPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		progressf("Failed to write history [%s]: %s\n", sh.historyPath, err)
+		sh.historyPath = ""
+		return
+	}
+
+	defer file.Close()
+
+	fmt.Fprintln(file, line)
+}
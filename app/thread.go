@@ -0,0 +1,498 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// Thread formats
+const (
+	ThreadText     = "text"
+	ThreadMarkdown = "markdown"
+	ThreadHtml     = "html"
+)
+
+var threadFormats = []string{ThreadText, ThreadMarkdown, ThreadHtml}
+
+// ThreadOptions selects the discussion printed by Thread.
+type ThreadOptions struct {
+	// Either a whole story or the subtree of a comment
+	StoryId   int
+	CommentId int
+
+	// text, markdown or html
+	Format string
+
+	// Levels below the root, which are printed. 0 prints all.
+	MaxDepth int
+
+	// Replies per comment, which are printed. The others are collapsed. 0 prints all.
+	Collapse int
+}
+
+type threadComment struct {
+	id     int
+	parent int
+	thread int
+	level  int
+	by     string
+	time   int64
+	dead   bool
+	text   string
+
+	replies []*threadComment
+}
+
+// threadRenderer prints a comment tree in one of the thread formats.
+type threadRenderer struct {
+	out *bufio.Writer
+
+	format   string
+	maxDepth int
+	collapse int
+}
+
+// ParseThreadFormat validates the -format flag of thread.
+func ParseThreadFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+
+	for _, knownFormat := range threadFormats {
+		if format == knownFormat {
+			return format
+		}
+	}
+
+	progressf("Unknown format [%s]. Use one of: %s\n", format, strings.Join(threadFormats, ", "))
+	os.Exit(1)
+
+	return ""
+}
+
+// Thread prints a story with its comment tree, or the replies to a single
+// comment. Only the thread goes to stdout, so it can be redirected to a file.
+func Thread(options ThreadOptions) {
+	progressf("Loading thread...")
+
+	conn := openDatabase()
+	defer conn.Close()
+
+	err := printThread(conn, options)
+	if err != nil {
+		progressf("%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// printThread writes the thread selected by options to the results.
+func printThread(conn *sqlite3.Conn, options ThreadOptions) error {
+	storyId := options.StoryId
+
+	if options.CommentId > 0 {
+		storyId = queryScalar(conn, "SELECT StoryId FROM Comments WHERE CommentId = ?", options.CommentId)
+
+		if storyId == 0 {
+			return fmt.Errorf("Comment %d not found or without story", options.CommentId)
+		}
+	}
+
+	comments := loadThreadComments(conn, storyId)
+
+	var root *threadComment
+
+	if options.CommentId > 0 {
+		root = comments[options.CommentId]
+	} else {
+		root = loadThreadStory(conn, storyId)
+		if root == nil {
+			return fmt.Errorf("Story %d not found", storyId)
+		}
+	}
+
+	for _, comment := range comments {
+		if parent, hasKey := comments[comment.parent]; hasKey {
+			parent.replies = append(parent.replies, comment)
+		} else if comment.parent == storyId && options.CommentId == 0 {
+			root.replies = append(root.replies, comment)
+		}
+	}
+
+	sortThreadReplies(root)
+
+	if Output != OutputText {
+		results := newResultWriter()
+		defer results.close()
+
+		writeThreadResults(results, root, 0, options.MaxDepth)
+		return nil
+	}
+
+	renderer := &threadRenderer{
+		out:      bufio.NewWriter(resultFile),
+		format:   options.Format,
+		maxDepth: options.MaxDepth,
+		collapse: options.Collapse,
+	}
+
+	renderer.render(root)
+
+	return renderer.out.Flush()
+}
+
+func loadThreadStory(conn *sqlite3.Conn, storyId int) *threadComment {
+	stmt, err := conn.Prepare(
+		"SELECT IFNULL(StoriesContent.Content, ''), IFNULL(Stories.By, ''), IFNULL(Stories.Time, 0), IFNULL(Stories.Url, ''), IFNULL(Stories.Text, '') "+
+			"FROM Stories LEFT JOIN StoriesContent ON (StoriesContent.rowid = Stories.StoryId) WHERE Stories.StoryId = ?", storyId)
+	check(err, "Failed to create query statememt")
+
+	defer stmt.Close()
+
+	hasRows, err := stmt.Step()
+	check(err, "Failed to step")
+
+	if !hasRows {
+		return nil
+	}
+
+	story := &threadComment{id: storyId}
+
+	var title string
+	var url string
+	var storyHtml string
+
+	err = stmt.Scan(&title, &story.by, &story.time, &url, &storyHtml)
+	check(err, "Failed to scan")
+
+	// The title is shown as text of the root
+	story.text = title
+	if url != "" {
+		story.text += "\n" + url
+	}
+
+	if storyHtml != "" {
+		parsed := parseComment(storyHtml)
+		story.text += "\n\n" + markQuotes(parsed.text, parsed.quotes)
+	}
+
+	return story
+}
+
+// loadThreadComments returns all comments of a story by id.
+func loadThreadComments(conn *sqlite3.Conn, storyId int) map[int]*threadComment {
+	comments := make(map[int]*threadComment)
+
+	stmt, err := conn.Prepare(
+		"SELECT Comments.CommentId, Comments.Parent, Comments.Thread, Comments.Level, IFNULL(Comments.By, ''), IFNULL(Comments.Time, 0), IFNULL(Comments.Dead, 0), "+
+			"IFNULL(CommentsContent.Content, '') FROM Comments "+
+			"LEFT JOIN CommentsContent ON (CommentsContent.rowid = Comments.CommentId) "+
+			"WHERE Comments.StoryId = ?", storyId)
+	check(err, "Failed to create query statememt")
+
+	defer stmt.Close()
+
+	for {
+		hasRows, err := stmt.Step()
+		check(err, "Failed to step")
+
+		if !hasRows {
+			break
+		}
+
+		comment := &threadComment{}
+
+		var dead int
+
+		err = stmt.Scan(&comment.id, &comment.parent, &comment.thread, &comment.level, &comment.by, &comment.time, &dead, &comment.text)
+		check(err, "Failed to scan")
+
+		comment.dead = dead != 0
+		comments[comment.id] = comment
+	}
+
+	for commentId, quotes := range loadThreadQuotes(conn, storyId) {
+		if comment, hasKey := comments[commentId]; hasKey {
+			comment.text = markQuotes(comment.text, quotes)
+		}
+	}
+
+	return comments
+}
+
+// loadThreadQuotes returns the quoted paragraphs of all comments of a story by comment id.
+func loadThreadQuotes(conn *sqlite3.Conn, storyId int) map[int][]string {
+	quotes := make(map[int][]string)
+
+	stmt, err := conn.Prepare(
+		"SELECT Quotes.CommentId, Quotes.Text FROM Quotes "+
+			"INNER JOIN Comments ON (Comments.CommentId = Quotes.CommentId) WHERE Comments.StoryId = ?", storyId)
+	check(err, "Failed to create query statememt")
+
+	defer stmt.Close()
+
+	var commentId int
+	var quote string
+
+	for {
+		hasRows, err := stmt.Step()
+		check(err, "Failed to step")
+
+		if !hasRows {
+			break
+		}
+
+		err = stmt.Scan(&commentId, &quote)
+		check(err, "Failed to scan")
+
+		quotes[commentId] = append(quotes[commentId], quote)
+	}
+
+	return quotes
+}
+
+// markQuotes puts the quote marker, which the normalizer removed, in front of
+// the quoted paragraphs again.
+func markQuotes(text string, quotes []string) string {
+	if len(quotes) == 0 {
+		return text
+	}
+
+	quoted := make(map[string]struct{})
+	for _, quote := range quotes {
+		quoted[quote] = struct{}{}
+	}
+
+	paragraphs := strings.Split(text, "\n\n")
+
+	for i, paragraph := range paragraphs {
+		if _, hasKey := quoted[paragraph]; hasKey {
+			paragraphs[i] = quoteMarker + paragraph
+		}
+	}
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// sortThreadReplies orders top level comments like the story and all other
+// replies by id, which is their age.
+func sortThreadReplies(comment *threadComment) {
+	sort.Slice(comment.replies, func(i, j int) bool {
+		first := comment.replies[i]
+		second := comment.replies[j]
+
+		if first.level == 1 && second.level == 1 && first.thread != second.thread {
+			// Thread 0 is unknown, e.g. comments of exports without kids
+			if first.thread == 0 || second.thread == 0 {
+				return second.thread == 0
+			}
+
+			return first.thread < second.thread
+		}
+
+		return first.id < second.id
+	})
+
+	for _, reply := range comment.replies {
+		sortThreadReplies(reply)
+	}
+}
+
+func (comment *threadComment) subtreeSize() int {
+	size := 1
+
+	for _, reply := range comment.replies {
+		size += reply.subtreeSize()
+	}
+
+	return size
+}
+
+func writeThreadResults(results *resultWriter, comment *threadComment, depth int, maxDepth int) {
+	results.write(
+		resultField{"id", comment.id},
+		resultField{"parent", comment.parent},
+		resultField{"depth", depth},
+		resultField{"by", comment.by},
+		resultField{"time", comment.time},
+		resultField{"dead", comment.dead},
+		resultField{"text", comment.text})
+
+	if maxDepth > 0 && depth >= maxDepth {
+		return
+	}
+
+	for _, reply := range comment.replies {
+		writeThreadResults(results, reply, depth+1, maxDepth)
+	}
+}
+
+func (renderer *threadRenderer) render(root *threadComment) {
+	if renderer.format == ThreadHtml {
+		fmt.Fprintf(renderer.out, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n",
+			html.EscapeString(strings.SplitN(root.text, "\n", 2)[0]))
+	}
+
+	renderer.renderComment(root, 0)
+
+	if renderer.format == ThreadHtml {
+		fmt.Fprintf(renderer.out, "</body>\n</html>\n")
+	}
+}
+
+func (renderer *threadRenderer) renderComment(comment *threadComment, depth int) {
+	renderer.renderHeader(comment, depth)
+
+	if len(comment.replies) == 0 {
+		renderer.renderEnd()
+		return
+	}
+
+	if renderer.maxDepth > 0 && depth >= renderer.maxDepth {
+		renderer.renderHidden(comment.subtreeSize()-1, "replies below max depth", depth+1)
+		renderer.renderEnd()
+		return
+	}
+
+	shownReplies := comment.replies
+	var collapsedReplies []*threadComment
+
+	if renderer.collapse > 0 && len(shownReplies) > renderer.collapse {
+		shownReplies = comment.replies[:renderer.collapse]
+		collapsedReplies = comment.replies[renderer.collapse:]
+	}
+
+	for _, reply := range shownReplies {
+		renderer.renderComment(reply, depth+1)
+	}
+
+	if len(collapsedReplies) > 0 {
+		if renderer.format == ThreadHtml {
+			// Collapsed replies are still there, one click away
+			fmt.Fprintf(renderer.out, "<details><summary>%d more replies</summary>\n", len(collapsedReplies))
+
+			for _, reply := range collapsedReplies {
+				renderer.renderComment(reply, depth+1)
+			}
+
+			fmt.Fprintf(renderer.out, "</details>\n")
+		} else {
+			collapsedCount := 0
+			for _, reply := range collapsedReplies {
+				collapsedCount += reply.subtreeSize()
+			}
+
+			renderer.renderHidden(collapsedCount, "more replies", depth+1)
+		}
+	}
+
+	renderer.renderEnd()
+}
+
+func (renderer *threadRenderer) renderHeader(comment *threadComment, depth int) {
+	header := comment.by
+	if header == "" {
+		header = "unknown"
+	}
+
+	if comment.time > 0 {
+		header += " " + time.Unix(comment.time, 0).UTC().Format("2006-01-02 15:04")
+	}
+
+	if comment.dead {
+		header += " [dead]"
+	}
+
+	itemUrl := fmt.Sprintf("https://news.ycombinator.com/item?id=%d", comment.id)
+
+	switch renderer.format {
+	case ThreadText:
+		indent := strings.Repeat("    ", depth)
+
+		fmt.Fprintf(renderer.out, "%s[%d] %s\n", indent, comment.id, header)
+		fmt.Fprintf(renderer.out, "%s\n\n", indentText(comment.text, indent))
+
+	case ThreadMarkdown:
+		quote := strings.Repeat("> ", depth)
+
+		lines := []string{fmt.Sprintf("**%s** [%d](%s)", header, comment.id, itemUrl), ""}
+		lines = append(lines, strings.Split(comment.text, "\n")...)
+
+		for _, line := range lines {
+			fmt.Fprintf(renderer.out, "%s\n", strings.TrimRight(quote+line, " "))
+		}
+
+		fmt.Fprintf(renderer.out, "%s\n", strings.TrimRight(quote, " "))
+
+	case ThreadHtml:
+		// Replies are nested, so every level adds its margin
+		margin := 0
+		if depth > 0 {
+			margin = 2
+		}
+
+		fmt.Fprintf(renderer.out, "<div style=\"margin-left: %dem\">\n<p><b>%s</b> <a href=\"%s\">%d</a></p>\n%s",
+			margin, html.EscapeString(header), itemUrl, comment.id, textHtml(comment.text))
+	}
+}
+
+// textHtml converts normalized text to HTML. The stored comment HTML is never
+// printed, so everything in it shows up as text and can't run in the page.
+func textHtml(text string) string {
+	var body strings.Builder
+
+	writeParagraphs := func(text string) {
+		for _, paragraph := range strings.Split(text, "\n\n") {
+			paragraph = strings.TrimSpace(paragraph)
+			if paragraph == "" {
+				continue
+			}
+
+			if strings.HasPrefix(paragraph, quoteMarker) {
+				body.WriteString("<blockquote><p>" + html.EscapeString(strings.TrimPrefix(paragraph, quoteMarker)) + "</p></blockquote>\n")
+				continue
+			}
+
+			body.WriteString("<p>" + strings.Replace(html.EscapeString(paragraph), "\n", "<br>\n", -1) + "</p>\n")
+		}
+	}
+
+	// Code blocks may contain empty lines, so they are cut out before splitting paragraphs
+	start := 0
+	for _, block := range reNormalizeCodeBlocks.FindAllStringIndex(text, -1) {
+		writeParagraphs(text[start:block[0]])
+
+		code := text[block[0]+len(codeFence)+1 : block[1]-len(codeFence)-1]
+		body.WriteString("<pre><code>" + html.EscapeString(code) + "</code></pre>\n")
+
+		start = block[1]
+	}
+
+	writeParagraphs(text[start:])
+
+	return body.String()
+}
+
+func (renderer *threadRenderer) renderHidden(count int, reason string, depth int) {
+	switch renderer.format {
+	case ThreadText:
+		fmt.Fprintf(renderer.out, "%s[+%d %s]\n\n", strings.Repeat("    ", depth), count, reason)
+
+	case ThreadMarkdown:
+		quote := strings.Repeat("> ", depth)
+		fmt.Fprintf(renderer.out, "%s*+%d %s*\n%s\n", quote, count, reason, strings.TrimRight(quote, " "))
+
+	case ThreadHtml:
+		fmt.Fprintf(renderer.out, "<p style=\"margin-left: 2em\"><i>+%d %s</i></p>\n", count, reason)
+	}
+}
+
+func (renderer *threadRenderer) renderEnd() {
+	if renderer.format == ThreadHtml {
+		fmt.Fprintf(renderer.out, "</div>\n")
+	}
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTextHtml(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "empty",
+			text: "",
+			want: "",
+		},
+		{
+			name: "paragraphs",
+			text: "First line\nsecond line\n\nNew paragraph",
+			want: "<p>First line<br>\nsecond line</p>\n<p>New paragraph</p>\n",
+		},
+		{
+			name: "script",
+			text: `<script>alert("hi")</script> & <a href="javascript:x()">link</a>`,
+			want: "<p>&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt; &amp; &lt;a href=&#34;javascript:x()&#34;&gt;link&lt;/a&gt;</p>\n",
+		},
+		{
+			name: "code block with empty line",
+			text: "Try this:\n\n```\nif x < 1 {\n\n  return\n}\n```\n\nDone",
+			want: "<p>Try this:</p>\n<pre><code>if x &lt; 1 {\n\n  return\n}</code></pre>\n<p>Done</p>\n",
+		},
+		{
+			name: "quote",
+			text: "> You said <this>\n\nI disagree",
+			want: "<blockquote><p>You said &lt;this&gt;</p></blockquote>\n<p>I disagree</p>\n",
+		},
+		{
+			name: "code in a script",
+			text: "```\n</code></pre><script>x()</script>\n```",
+			want: "<pre><code>&lt;/code&gt;&lt;/pre&gt;&lt;script&gt;x()&lt;/script&gt;</code></pre>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := textHtml(test.text)
+
+			if got != test.want {
+				t.Errorf("\n got: %q\nwant: %q", got, test.want)
+			}
+
+			if strings.Contains(got, "<script") {
+				t.Errorf("Script tag in output: %q", got)
+			}
+		})
+	}
+}
+
+func TestSortThreadReplies(t *testing.T) {
+	root := &threadComment{id: 1, replies: []*threadComment{
+		{id: 2, level: 1, thread: 0},
+		{id: 5, level: 1, thread: 2},
+		{id: 3, level: 1, thread: 1},
+		{id: 4, level: 1, thread: 0},
+		{id: 6, level: 1, thread: 3, replies: []*threadComment{
+			{id: 9, level: 2},
+			{id: 7, level: 2},
+		}},
+	}}
+
+	sortThreadReplies(root)
+
+	var ids []int
+	for _, reply := range root.replies {
+		ids = append(ids, reply.id)
+	}
+
+	if fmt.Sprint(ids) != "[3 5 6 2 4]" {
+		t.Errorf("Top level order %v, expected threads 1, 2, 3 and then unknown threads by id", ids)
+	}
+
+	if root.replies[2].replies[0].id != 7 {
+		t.Errorf("Replies aren't ordered by id")
+	}
+}
+
+func TestMarkQuotes(t *testing.T) {
+	parsed := parseComment("<p>&gt; The sky is blue today.</p><p>No, it's grey.</p>")
+
+	got := markQuotes(parsed.text, parsed.quotes)
+	want := "> The sky is blue today.\n\nNo, it's grey."
+
+	if got != want {
+		t.Errorf("\n got: %q\nwant: %q", got, want)
+	}
+}
@@ -4,5 +4,9 @@ go 1.12
 
 require (
 	github.com/bvinc/go-sqlite-lite v0.6.1
+	github.com/klauspost/compress v1.11.13
+	github.com/peterh/liner v1.2.2
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 	golang.org/x/tools/gopls v0.1.3 // indirect
 )
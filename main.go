@@ -4,31 +4,125 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/hacker-bro/app" // WTF: go help importpath
 )
 
+// stringList collects the values of a flag, which can be passed multiple times.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func isFlagSet(flagSet *flag.FlagSet, name string) bool {
+	isSet := false
+
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			isSet = true
+		}
+	})
+
+	return isSet
+}
+
+// defaultShellHistoryPath is .hacker-bro_history in the home directory, or in the current one.
+func defaultShellHistoryPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ".hacker-bro_history"
+	}
+
+	return filepath.Join(homeDir, ".hacker-bro_history")
+}
+
 func main() {
 
 	// Subcommands / Flags: https://bit.ly/2Lf3igu
+	fetchCommand := flag.NewFlagSet("fetch", flag.ExitOnError)
 	importCommand := flag.NewFlagSet("import", flag.ExitOnError)
+	migrateCommand := flag.NewFlagSet("migrate", flag.ExitOnError)
 	queryCommand := flag.NewFlagSet("query", flag.ExitOnError)
 	rankCommand := flag.NewFlagSet("rank", flag.ExitOnError)
+	recleanCommand := flag.NewFlagSet("reclean", flag.ExitOnError)
+	shellCommand := flag.NewFlagSet("shell", flag.ExitOnError)
 	statusCommand := flag.NewFlagSet("status", flag.ExitOnError)
 	talkCommand := flag.NewFlagSet("talk", flag.ExitOnError)
+	threadCommand := flag.NewFlagSet("thread", flag.ExitOnError)
+
+	// Global Flags, also accepted after the subcommand
+	databaseUsage := "Database file, or a name like 2019 for hacker-bro-2019.db. Default is $HACKER_BRO_DB or hacker-bro.db."
+	databasePtr := flag.String("db", os.Getenv("HACKER_BRO_DB"), databaseUsage)
+	durabilityUsage := "fast (no journal, exclusive lock), safe (rollback journal) or wal (readers work during imports)"
+	durabilityPtr := flag.String("durability", app.DurabilityWal, durabilityUsage)
+	outputUsage := "Result format of query, status and rank: text, json, csv or ndjson. Progress always goes to stderr."
+	outputPtr := flag.String("output", app.OutputText, outputUsage)
+
+	for _, flagSet := range []*flag.FlagSet{fetchCommand, importCommand, migrateCommand, queryCommand, rankCommand, recleanCommand, shellCommand, statusCommand, talkCommand, threadCommand} {
+		flagSet.StringVar(databasePtr, "db", *databasePtr, databaseUsage)
+		flagSet.StringVar(durabilityPtr, "durability", *durabilityPtr, durabilityUsage)
+		flagSet.StringVar(outputPtr, "output", *outputPtr, outputUsage)
+	}
+
+	// Fetch Flags
+	fetchBaseUrlPtr := fetchCommand.String("base-url", "https://hacker-news.firebaseio.com/v0", "API root. Point it to a local server for testing.")
+	fetchFromPtr := fetchCommand.Int("from", 0, "First item id. Default is the current maxitem.")
+	fetchToPtr := fetchCommand.Int("to", 1, "Last item id. Items are fetched downwards.")
+	fetchCountPtr := fetchCommand.Int("count", 0, "Maximum number of ids per run. Default is no limit.")
+	fetchConcurrencyPtr := fetchCommand.Int("concurrency", 8, "Number of parallel requests")
+	fetchRetriesPtr := fetchCommand.Int("retries", 5, "Retries per item with exponential backoff")
+	fetchOutPtr := fetchCommand.String("out", "", "Append items to this Json file instead of inserting them into the database")
+	fetchSourcePtr := fetchCommand.String("source", "api", "Name stored for items inserted into the database")
+	fetchCheckpointPtr := fetchCommand.String("checkpoint", "fetch-checkpoint.json", "Progress file. An interrupted fetch resumes from here.")
 
 	// Import Flags
-	dirPtr := importCommand.String("dir", "", "Directory with Json files")
+	dirPtr := importCommand.String("dir", "", "Directory with Json files. Subdirectories are included.")
+	var importInclude, importExclude stringList
+	importCommand.Var(&importInclude, "include", "Only import files matching this glob pattern, e.g. *.json*. Can be repeated.")
+	importCommand.Var(&importExclude, "exclude", "Skip files matching this glob pattern. Can be repeated.")
+	filesPtr := importCommand.Bool("files", false, "Import the files given as arguments instead of a directory")
+	sourcePtr := importCommand.String("source", "", "Name stored for the imported file. Required for stdin.")
+	lenientPtr := importCommand.Bool("lenient", false, "Write bad lines to the quarantine file and continue")
+	quarantinePtr := importCommand.String("quarantine", "quarantine.jsonl", "Quarantine file for bad lines. Implies -lenient.")
+	workersPtr := importCommand.Int("workers", runtime.NumCPU(), "Number of concurrent Json decoders")
+	removePtr := importCommand.String("remove", "", "Remove everything imported from this file name instead of importing")
+	formatPtr := importCommand.String("format", "auto", "Input format: hn (one API item per line), algolia (search hits), bigquery (CSV export) or auto")
+	reportPtr := importCommand.String("report", "", "Write counters per file, timings and orphans to this Json file")
+	replacePtr := importCommand.Bool("replace", false, "Remove the old items of each file and import it again in a single transaction")
+
+	// Migrate Flags
+	migrateDryRunPtr := migrateCommand.Bool("dry-run", false, "Only list pending migrations")
 
 	// Query Flags
-	queryPtr := queryCommand.String("q", "", "Database query")
+	queryPtr := queryCommand.String("q", "", "Search query: words, \"phrases\", AND, OR, NOT, NEAR(a b, 5), prefix*, level:<=2, thread:<=3, story:123, by:name")
+	queryKindsPtr := queryCommand.String("kinds", "", "Comma separated story kinds: story, ask, show, poll. Default is all.")
+	queryDomainsPtr := queryCommand.Bool("domains", false, "List the most linked domains instead")
+	queryQuotesPtr := queryCommand.Bool("quotes", false, "List comments quoting their parent instead")
+	queryLimitPtr := queryCommand.Int("limit", 20, "Maximum number of listed results")
+	queryOffsetPtr := queryCommand.Int("offset", 0, "Number of results to skip, for paging")
+	queryFullPtr := queryCommand.Bool("full", false, "Print whole comments with highlighted matches instead of snippets")
 
 	// Rank Flags
-	filterPtr := rankCommand.String("filter", "", "Comment word filter")
+	filterPtr := rankCommand.String("filter", "", "Comment filter in the query syntax, e.g. (rust OR go) level:<=2")
+	rankKindsPtr := rankCommand.String("kinds", "", "Comma separated story kinds: story, ask, show, poll. Default is all.")
 	rankConfPtr := rankCommand.String("conf", "", "Output config file path")
 	rankCommentLimitPtr := rankCommand.Int("commentLimit", 0, "Maximum number of comments to look at")
 	rankVerbosePtr := rankCommand.Bool("verbose", false, "Verbose output")
 
+	// Shell Flags
+	shellHistoryPtr := shellCommand.String("history", defaultShellHistoryPath(), "History file. Empty disables it.")
+	shellConfPtr := shellCommand.String("conf", "", "Word map for talk, written by rank. Can also be loaded in the shell.")
+	shellLimitPtr := shellCommand.Int("limit", 10, "Comments per page of search")
+
 	// Talk Flags
 	talkConfPtr := talkCommand.String("conf", "", "Input config file path")
 	talkVerbosePtr := talkCommand.Bool("verbose", false, "Verbose output")
@@ -39,38 +133,78 @@ func main() {
 	talkRandSeed1Ptr := talkCommand.Int("randInit", 0, "Random number seed for first word.")
 	talkRandSeed2Ptr := talkCommand.Int("randTalk", 0, "Random number seed for word sequence.")
 
-	if len(os.Args) < 2 || (os.Args[1] != "import" && os.Args[1] != "query" && os.Args[1] != "rank" && os.Args[1] != "status" && os.Args[1] != "talk") {
-		fmt.Println("Please provide a subcommand: import, status, rank, talk")
+	// Thread Flags
+	threadStoryPtr := threadCommand.Int("story", 0, "Story id. Prints the story with all comments.")
+	threadCommentPtr := threadCommand.Int("comment", 0, "Comment id. Prints the comment with its replies.")
+	threadFormatPtr := threadCommand.String("format", "text", "Thread format: text, markdown or html")
+	threadMaxDepthPtr := threadCommand.Int("max-depth", 0, "Maximum reply depth below the story or comment. Default is all.")
+	threadCollapsePtr := threadCommand.Int("collapse", 0, "Replies shown per comment, the others are collapsed. Default is all.")
+
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 1 || (args[0] != "fetch" && args[0] != "import" && args[0] != "migrate" && args[0] != "query" && args[0] != "rank" && args[0] != "reclean" && args[0] != "shell" && args[0] != "status" && args[0] != "talk" && args[0] != "thread") {
+		fmt.Println("Please provide a subcommand: fetch, import, migrate, query, status, rank, reclean, shell, talk, thread")
 		os.Exit(1)
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
+	case "fetch":
+		err := fetchCommand.Parse(args[1:])
+		if err != nil {
+			fmt.Println("Failed to parse command")
+			os.Exit(1)
+		}
 	case "import":
-		err := importCommand.Parse(os.Args[2:])
+		err := importCommand.Parse(args[1:])
+		if err != nil {
+			fmt.Println("Failed to parse command")
+			os.Exit(1)
+		}
+	case "migrate":
+		err := migrateCommand.Parse(args[1:])
 		if err != nil {
 			fmt.Println("Failed to parse command")
 			os.Exit(1)
 		}
 	case "query":
-		err := queryCommand.Parse(os.Args[2:])
+		err := queryCommand.Parse(args[1:])
 		if err != nil {
 			fmt.Println("Failed to parse command")
 			os.Exit(1)
 		}
 	case "rank":
-		err := rankCommand.Parse(os.Args[2:])
+		err := rankCommand.Parse(args[1:])
+		if err != nil {
+			fmt.Println("Failed to parse command")
+			os.Exit(1)
+		}
+	case "reclean":
+		err := recleanCommand.Parse(args[1:])
+		if err != nil {
+			fmt.Println("Failed to parse command")
+			os.Exit(1)
+		}
+	case "shell":
+		err := shellCommand.Parse(args[1:])
 		if err != nil {
 			fmt.Println("Failed to parse command")
 			os.Exit(1)
 		}
 	case "status":
-		err := statusCommand.Parse(os.Args[2:])
+		err := statusCommand.Parse(args[1:])
 		if err != nil {
 			fmt.Println("Failed to parse command")
 			os.Exit(1)
 		}
 	case "talk":
-		err := talkCommand.Parse(os.Args[2:])
+		err := talkCommand.Parse(args[1:])
+		if err != nil {
+			fmt.Println("Failed to parse command")
+			os.Exit(1)
+		}
+	case "thread":
+		err := threadCommand.Parse(args[1:])
 		if err != nil {
 			fmt.Println("Failed to parse command")
 			os.Exit(1)
@@ -80,22 +214,92 @@ func main() {
 		os.Exit(1)
 	}
 
-	if importCommand.Parsed() {
-		if *dirPtr == "" {
+	app.DatabasePath = app.DatabasePathFor(*databasePtr)
+	app.Durability = app.ParseDurability(*durabilityPtr)
+	app.SetOutput(*outputPtr)
+
+	if fetchCommand.Parsed() {
+
+		app.Fetch(app.FetchOptions{
+			BaseUrl:        *fetchBaseUrlPtr,
+			From:           *fetchFromPtr,
+			To:             *fetchToPtr,
+			Count:          *fetchCountPtr,
+			Concurrency:    *fetchConcurrencyPtr,
+			Retries:        *fetchRetriesPtr,
+			OutputPath:     *fetchOutPtr,
+			Source:         *fetchSourcePtr,
+			CheckpointPath: *fetchCheckpointPtr,
+		})
+
+	} else if importCommand.Parsed() {
+
+		if *removePtr != "" {
+			if *dirPtr != "" || importCommand.NArg() > 0 {
+				fmt.Println("Usage: import -remove <file>")
+				os.Exit(1)
+			}
+
+			app.RemoveFile(*removePtr)
+			return
+		}
+
+		var files []string
+
+		if *filesPtr {
+			files = importCommand.Args()
+		} else if importCommand.NArg() == 1 && importCommand.Arg(0) == "-" {
+			files = []string{"-"}
+		}
+
+		hasDir := *dirPtr != ""
+		hasFiles := len(files) > 0
+
+		// Exactly one of -dir, -files or stdin. No stray arguments.
+		if hasDir == hasFiles || (!hasFiles && importCommand.NArg() > 0) {
+			fmt.Println("Usage: import -dir <dir> | import -files <file>... | import -source <name> - | import -remove <file>")
 			importCommand.PrintDefaults()
 			os.Exit(1)
 		}
 
-		app.Import(*dirPtr)
+		app.Import(app.ImportOptions{
+			Dir:     *dirPtr,
+			Include: importInclude,
+			Exclude: importExclude,
+			Files:   files,
+			Source:  *sourcePtr,
+
+			Lenient:        *lenientPtr || isFlagSet(importCommand, "quarantine"),
+			QuarantinePath: *quarantinePtr,
+
+			Workers:    *workersPtr,
+			Format:     app.ParseInputFormat(*formatPtr),
+			ReportPath: *reportPtr,
+			Replace:    *replacePtr,
+		})
+
+	} else if migrateCommand.Parsed() {
+
+		app.Migrate(*migrateDryRunPtr)
 
 	} else if queryCommand.Parsed() {
 
+		if *queryDomainsPtr {
+			app.TopDomains(*queryLimitPtr)
+			return
+		}
+
+		if *queryQuotesPtr {
+			app.ParentQuotes(*queryLimitPtr)
+			return
+		}
+
 		if *queryPtr == "" {
 			queryCommand.PrintDefaults()
 			os.Exit(1)
 		}
 
-		app.Query(*queryPtr)
+		app.Query(*queryPtr, app.ParseKinds(*queryKindsPtr), *queryLimitPtr, *queryOffsetPtr, *queryFullPtr)
 
 	} else if rankCommand.Parsed() {
 
@@ -104,7 +308,19 @@ func main() {
 			os.Exit(1)
 		}
 
-		app.Rank(*filterPtr, *rankConfPtr, *rankCommentLimitPtr, *rankVerbosePtr)
+		app.Rank(*filterPtr, app.ParseKinds(*rankKindsPtr), *rankConfPtr, *rankCommentLimitPtr, *rankVerbosePtr)
+
+	} else if recleanCommand.Parsed() {
+
+		app.Reclean()
+
+	} else if shellCommand.Parsed() {
+
+		app.Shell(app.ShellOptions{
+			HistoryPath:    *shellHistoryPtr,
+			WordConfigPath: *shellConfPtr,
+			Limit:          *shellLimitPtr,
+		})
 
 	} else if statusCommand.Parsed() {
 
@@ -118,5 +334,21 @@ func main() {
 		}
 
 		app.Talk(*talkConfPtr, *talkCountPtr, *talkContinuityPtr, *talkStabilityPtr, *talkInitPtr, *talkRandSeed1Ptr, *talkRandSeed2Ptr, *talkVerbosePtr)
+
+	} else if threadCommand.Parsed() {
+
+		if (*threadStoryPtr > 0) == (*threadCommentPtr > 0) {
+			fmt.Println("Please provide either -story or -comment")
+			threadCommand.PrintDefaults()
+			os.Exit(1)
+		}
+
+		app.Thread(app.ThreadOptions{
+			StoryId:   *threadStoryPtr,
+			CommentId: *threadCommentPtr,
+			Format:    app.ParseThreadFormat(*threadFormatPtr),
+			MaxDepth:  *threadMaxDepthPtr,
+			Collapse:  *threadCollapsePtr,
+		})
 	}
 }